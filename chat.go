@@ -0,0 +1,70 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ChatChannel delivers notifications as markdown messages to a chat
+// incoming webhook (Slack, Mattermost and compatible services)
+type ChatChannel struct {
+	WebhookURL string
+	Templates  *Templates
+	Client     *http.Client
+}
+
+// NewChatChannel creates a ChatChannel posting to webhookURL
+func NewChatChannel(webhookURL string, templates *Templates) ChatChannel {
+	return ChatChannel{
+		WebhookURL: webhookURL,
+		Templates:  templates,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c ChatChannel) Name() string {
+	return "chat"
+}
+
+// Recipient identifies the webhook by host only, the full URL is a secret
+func (c ChatChannel) Recipient() string {
+	if u, err := url.Parse(c.WebhookURL); err == nil {
+		return "chat:" + u.Host
+	}
+
+	return "chat"
+}
+
+type chatMessage struct {
+	Text string `json:"text"`
+}
+
+func (c ChatChannel) Send(n Notification) error {
+	data := NewTemplateData(n)
+
+	text, err := c.Templates.Render("chat", data.Event, FormatMarkdown, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(chatMessage{Text: text})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Client.Post(c.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("chat webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
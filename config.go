@@ -0,0 +1,575 @@
+package notifier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Duration is a time.Duration written as a string such as "30m" in
+// config files
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+type DiscogsConfig struct {
+	Username string `yaml:"username"`
+	Token    string `yaml:"token"`
+	Currency string `yaml:"currency"`
+	APIURL   string `yaml:"api_url"`
+}
+
+type SMTPConfig struct {
+	Address         string        `yaml:"address"`
+	Port            int           `yaml:"port"`
+	TLSMode         TLSMode       `yaml:"tls_mode"`
+	Auth            AuthMechanism `yaml:"auth"`
+	Username        string        `yaml:"username"`
+	Password        string        `yaml:"password"`
+	From            string        `yaml:"from"`
+	To              []string      `yaml:"to"`
+	Bcc             []string      `yaml:"bcc"`
+	CAFile          string        `yaml:"ca_file"`
+	Timeout         Duration      `yaml:"timeout"`
+	ListUnsubscribe string        `yaml:"list_unsubscribe"`
+}
+
+type ChatConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// HealthConfig configures monitoring of the notifier
+type HealthConfig struct {
+	HeartbeatURL   string   `yaml:"heartbeat_url"`
+	StallThreshold Duration `yaml:"stall_threshold"`
+}
+
+// AdminConfig configures who is alerted of problems with the notifier
+type AdminConfig struct {
+	Email []string `yaml:"email"`
+}
+
+// PipelineConfig sets the number of concurrent workers for each stage of
+// a cycle. Every stage shares the same rate limit
+type PipelineConfig struct {
+	ListWorkers   int `yaml:"list_workers"`
+	StatsWorkers  int `yaml:"stats_workers"`
+	ScrapeWorkers int `yaml:"scrape_workers"`
+}
+
+// ScraperConfig configures scraping of marketplace sell pages
+type ScraperConfig struct {
+	// Selectors is a selector profile file overriding the default selectors
+	Selectors string `yaml:"selectors"`
+	// MaxPages is the most sell pages scraped for a release
+	MaxPages int `yaml:"max_pages"`
+}
+
+// ImportCharge is a row of the import charges table. It applies to
+// listings shipping from one of From to one of To, both countries or
+// regions (empty matches anywhere). The first matching row is used
+type ImportCharge struct {
+	From []string `yaml:"from"`
+	To   []string `yaml:"to"`
+	// Duty and VAT are percentages of the declared value (price and
+	// shipping), charged when it's over their threshold. VAT is also
+	// charged on the duty
+	Duty          float64 `yaml:"duty"`
+	DutyThreshold float64 `yaml:"duty_threshold"`
+	VAT           float64 `yaml:"vat"`
+	VATThreshold  float64 `yaml:"vat_threshold"`
+	// Fee is the carrier's handling fee when duty or VAT is charged
+	Fee float64 `yaml:"fee"`
+}
+
+// ImportConfig configures estimating the landed cost of listings.
+// Thresholds and fees are in the user's currency
+type ImportConfig struct {
+	// Country is the country listings are delivered to
+	Country string         `yaml:"country"`
+	Charges []ImportCharge `yaml:"charges"`
+}
+
+// JobConfig bounds a single cycle run by the 'once' command
+type JobConfig struct {
+	Timeout      Duration `yaml:"timeout"`
+	FlushTimeout Duration `yaml:"flush_timeout"`
+}
+
+type NotifyConfig struct {
+	Cooldown    Duration `yaml:"cooldown"`
+	Workers     int      `yaml:"workers"`
+	MaxAttempts int      `yaml:"max_attempts"`
+	RetryBase   Duration `yaml:"retry_base"`
+	RetryMax    Duration `yaml:"retry_max"`
+	DryRun      bool     `yaml:"dry_run"`
+	DecisionLog string   `yaml:"decision_log"`
+	// Items failing QuarantineAfter cycles in a row are skipped for
+	// QuarantineFor
+	QuarantineAfter int      `yaml:"quarantine_after"`
+	QuarantineFor   Duration `yaml:"quarantine_for"`
+}
+
+// Config is the configuration of the notifier
+type Config struct {
+	Discogs         DiscogsConfig  `yaml:"discogs"`
+	SMTP            SMTPConfig     `yaml:"smtp"`
+	Chat            ChatConfig     `yaml:"chat"`
+	Notify          NotifyConfig   `yaml:"notify"`
+	Pipeline        PipelineConfig `yaml:"pipeline"`
+	Scraper         ScraperConfig  `yaml:"scraper"`
+	Imports         ImportConfig   `yaml:"imports"`
+	Job             JobConfig      `yaml:"job"`
+	Health          HealthConfig   `yaml:"health"`
+	Admin           AdminConfig    `yaml:"admin"`
+	DataDir         string         `yaml:"data_dir"`
+	TemplateDir     string         `yaml:"template_dir"`
+	ReleaseCacheTTL Duration       `yaml:"release_cache_ttl"`
+	// PriceHistory is how long lowest prices are kept for relative rules
+	PriceHistory Duration `yaml:"price_history"`
+	Verbose      bool     `yaml:"verbose"`
+	Port         int      `yaml:"port"`
+}
+
+// DefaultConfig returns the configuration used for any settings not set
+// by the config file or environment
+func DefaultConfig() Config {
+	return Config{
+		Discogs: DiscogsConfig{
+			Currency: "USD",
+			APIURL:   "https://api.discogs.com",
+		},
+		SMTP: SMTPConfig{
+			Port:    587,
+			Timeout: Duration(30 * time.Second),
+		},
+		Notify: NotifyConfig{
+			Cooldown:        Duration(30 * time.Minute),
+			Workers:         2,
+			MaxAttempts:     8,
+			RetryBase:       Duration(30 * time.Second),
+			RetryMax:        Duration(time.Hour),
+			QuarantineAfter: 5,
+			QuarantineFor:   Duration(24 * time.Hour),
+		},
+		Pipeline: PipelineConfig{
+			ListWorkers:   2,
+			StatsWorkers:  4,
+			ScrapeWorkers: 2,
+		},
+		Scraper: ScraperConfig{
+			MaxPages: 3,
+		},
+		Job: JobConfig{
+			Timeout:      Duration(10 * time.Minute),
+			FlushTimeout: Duration(2 * time.Minute),
+		},
+		Health: HealthConfig{
+			StallThreshold: Duration(time.Hour),
+		},
+		DataDir:         "data",
+		ReleaseCacheTTL: Duration(7 * 24 * time.Hour),
+		PriceHistory:    Duration(90 * 24 * time.Hour),
+		Port:            8080,
+	}
+}
+
+// Currencies supported by the discogs marketplace
+var Currencies = []string{"USD", "GBP", "EUR", "CAD", "AUD", "JPY", "CHF", "MXN", "BRL", "NZD", "SEK", "ZAR"}
+
+// envOverride maps an environment variable to the setting it overrides
+type envOverride struct {
+	Key    string
+	Target interface{}
+}
+
+// envOverrides returns the environment variables which override settings
+func (c *Config) envOverrides() []envOverride {
+	return []envOverride{
+		{"DISCOGS_USERNAME", &c.Discogs.Username},
+		{"DISCOGS_TOKEN", &c.Discogs.Token},
+		{"CURRENCY", &c.Discogs.Currency},
+		{"DISCOGS_API_URL", &c.Discogs.APIURL},
+		{"SMTP_ADDRESS", &c.SMTP.Address},
+		{"SMTP_TLS_PORT", &c.SMTP.Port},
+		{"SMTP_TLS_MODE", &c.SMTP.TLSMode},
+		{"SMTP_AUTH", &c.SMTP.Auth},
+		{"SMTP_USERNAME", &c.SMTP.Username},
+		{"SMTP_PASSWORD", &c.SMTP.Password},
+		{"SMTP_FROM", &c.SMTP.From},
+		{"USER_EMAIL", &c.SMTP.To},
+		{"USER_BCC", &c.SMTP.Bcc},
+		{"SMTP_CA_FILE", &c.SMTP.CAFile},
+		{"SMTP_TIMEOUT", &c.SMTP.Timeout},
+		{"LIST_UNSUBSCRIBE", &c.SMTP.ListUnsubscribe},
+		{"CHAT_WEBHOOK_URL", &c.Chat.WebhookURL},
+		{"NOTIFY_COOLDOWN", &c.Notify.Cooldown},
+		{"NOTIFY_WORKERS", &c.Notify.Workers},
+		{"NOTIFY_MAX_ATTEMPTS", &c.Notify.MaxAttempts},
+		{"NOTIFY_RETRY_BASE", &c.Notify.RetryBase},
+		{"DRY_RUN", &c.Notify.DryRun},
+		{"DECISION_LOG", &c.Notify.DecisionLog},
+		{"NOTIFY_QUARANTINE_AFTER", &c.Notify.QuarantineAfter},
+		{"NOTIFY_QUARANTINE_FOR", &c.Notify.QuarantineFor},
+		{"PIPELINE_LIST_WORKERS", &c.Pipeline.ListWorkers},
+		{"PIPELINE_STATS_WORKERS", &c.Pipeline.StatsWorkers},
+		{"PIPELINE_SCRAPE_WORKERS", &c.Pipeline.ScrapeWorkers},
+		{"SCRAPER_SELECTORS", &c.Scraper.Selectors},
+		{"SCRAPER_MAX_PAGES", &c.Scraper.MaxPages},
+		{"IMPORT_COUNTRY", &c.Imports.Country},
+		{"JOB_TIMEOUT", &c.Job.Timeout},
+		{"JOB_FLUSH_TIMEOUT", &c.Job.FlushTimeout},
+		{"HEARTBEAT_URL", &c.Health.HeartbeatURL},
+		{"STALL_THRESHOLD", &c.Health.StallThreshold},
+		{"ADMIN_EMAIL", &c.Admin.Email},
+		{"DATA_DIR", &c.DataDir},
+		{"TEMPLATE_DIR", &c.TemplateDir},
+		{"RELEASE_CACHE_TTL", &c.ReleaseCacheTTL},
+		{"PRICE_HISTORY", &c.PriceHistory},
+		{"VERBOSE", &c.Verbose},
+		{"PORT", &c.Port},
+	}
+}
+
+// ApplyEnv overrides settings with any environment variables that are set.
+// Empty variables are ignored
+func (c *Config) ApplyEnv() error {
+	for _, override := range c.envOverrides() {
+		value := strings.TrimSpace(os.Getenv(override.Key))
+		if value == "" {
+			continue
+		}
+
+		var err error
+		switch target := override.Target.(type) {
+		case *string:
+			*target = value
+		case *TLSMode:
+			*target = TLSMode(value)
+		case *AuthMechanism:
+			*target = AuthMechanism(value)
+		case *[]string:
+			*target = splitList(value)
+		case *int:
+			*target, err = strconv.Atoi(value)
+		case *bool:
+			*target, err = strconv.ParseBool(value)
+		case *Duration:
+			var duration time.Duration
+			duration, err = time.ParseDuration(value)
+			*target = Duration(duration)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", override.Key, err)
+		}
+	}
+
+	return nil
+}
+
+// normalise fills in settings which default based on other settings
+func (c *Config) normalise() {
+	c.Discogs.Currency = strings.ToUpper(c.Discogs.Currency)
+	c.Discogs.APIURL = strings.TrimRight(c.Discogs.APIURL, "/")
+
+	c.SMTP.TLSMode = TLSMode(strings.ToLower(string(c.SMTP.TLSMode)))
+	if c.SMTP.TLSMode == "" {
+		c.SMTP.TLSMode = TLSModeStartTLS
+		if c.SMTP.Port == 465 {
+			c.SMTP.TLSMode = TLSModeImplicit
+		}
+	}
+
+	c.SMTP.Auth = AuthMechanism(strings.ToLower(string(c.SMTP.Auth)))
+	if c.SMTP.Auth == "" {
+		c.SMTP.Auth = AuthNone
+		if c.SMTP.Username != "" {
+			c.SMTP.Auth = AuthPlain
+		}
+	}
+
+	if c.SMTP.From == "" {
+		c.SMTP.From = c.SMTP.Username
+	}
+
+	if c.SMTP.ListUnsubscribe == "" && c.SMTP.From != "" {
+		from := c.SMTP.From
+		if address, err := envelopeAddress(from); err == nil {
+			from = address
+		}
+		c.SMTP.ListUnsubscribe = "mailto:" + from + "?subject=unsubscribe"
+	}
+
+	// Locations are matched in their canonical form, invalid ones are left
+	// for Validate to report
+	if code, ok := CountryCode(c.Imports.Country); ok {
+		c.Imports.Country = code
+	}
+	for i := range c.Imports.Charges {
+		normaliseLocations(c.Imports.Charges[i].From)
+		normaliseLocations(c.Imports.Charges[i].To)
+	}
+}
+
+// normaliseLocations replaces locations with their canonical form
+func normaliseLocations(locations []string) {
+	for i, location := range locations {
+		if parsed, err := ParseLocation(location); err == nil {
+			locations[i] = parsed
+		}
+	}
+}
+
+// LoadConfig loads the config file at path (if path is not empty) over
+// the defaults, then applies environment variable overrides.
+// Unknown settings in the file are an error
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := yaml.UnmarshalStrict(data, &config); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+	}
+
+	if err := config.ApplyEnv(); err != nil {
+		return nil, err
+	}
+
+	config.normalise()
+
+	return &config, nil
+}
+
+// ValidationError lists every problem found with a config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the config is usable, returning a ValidationError
+// listing all problems found
+func (c *Config) Validate() error {
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Discogs.Username == "" {
+		problem("discogs.username (DISCOGS_USERNAME) is required")
+	}
+	if c.Discogs.Token == "" {
+		problem("discogs.token (DISCOGS_TOKEN) is required")
+	}
+	if !containsString(Currencies, c.Discogs.Currency) {
+		problem("discogs.currency (CURRENCY) '%s' must be one of %s", c.Discogs.Currency, strings.Join(Currencies, ", "))
+	}
+	if !strings.HasPrefix(c.Discogs.APIURL, "http://") && !strings.HasPrefix(c.Discogs.APIURL, "https://") {
+		problem("discogs.api_url (DISCOGS_API_URL) '%s' must be an http(s) url", c.Discogs.APIURL)
+	}
+
+	// A dry run only logs decisions so doesn't need anywhere to notify
+	if len(c.SMTP.To) == 0 && len(c.SMTP.Bcc) == 0 && c.Chat.WebhookURL == "" && !c.Notify.DryRun {
+		problem("at least one of smtp.to (USER_EMAIL), smtp.bcc (USER_BCC) or chat.webhook_url (CHAT_WEBHOOK_URL) is required")
+	}
+
+	if len(c.SMTP.To) > 0 || len(c.SMTP.Bcc) > 0 {
+		if c.SMTP.Address == "" {
+			problem("smtp.address (SMTP_ADDRESS) is required to send email")
+		}
+		if c.SMTP.From == "" {
+			problem("smtp.from (SMTP_FROM) or smtp.username (SMTP_USERNAME) is required to send email")
+		}
+	}
+	if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+		problem("smtp.port (SMTP_TLS_PORT) %d is not a valid port", c.SMTP.Port)
+	}
+
+	switch c.SMTP.TLSMode {
+	case TLSModeNone, TLSModeStartTLS, TLSModeStartTLSRequired, TLSModeImplicit:
+	default:
+		problem("smtp.tls_mode (SMTP_TLS_MODE) '%s' must be one of implicit, starttls, starttls-required or none", c.SMTP.TLSMode)
+	}
+
+	switch c.SMTP.Auth {
+	case AuthNone:
+	case AuthPlain, AuthLogin, AuthCRAMMD5:
+		if c.SMTP.Username == "" || c.SMTP.Password == "" {
+			problem("smtp.username (SMTP_USERNAME) and smtp.password (SMTP_PASSWORD) are required for %s auth", c.SMTP.Auth)
+		}
+	default:
+		problem("smtp.auth (SMTP_AUTH) '%s' must be one of plain, login, cram-md5 or none", c.SMTP.Auth)
+	}
+
+	if c.SMTP.CAFile != "" {
+		if _, err := os.Stat(c.SMTP.CAFile); err != nil {
+			problem("smtp.ca_file (SMTP_CA_FILE) %v", err)
+		}
+	}
+
+	if c.Chat.WebhookURL != "" && !strings.HasPrefix(c.Chat.WebhookURL, "https://") && !strings.HasPrefix(c.Chat.WebhookURL, "http://") {
+		problem("chat.webhook_url (CHAT_WEBHOOK_URL) must be an http(s) url")
+	}
+
+	if c.Health.HeartbeatURL != "" && !strings.HasPrefix(c.Health.HeartbeatURL, "https://") && !strings.HasPrefix(c.Health.HeartbeatURL, "http://") {
+		problem("health.heartbeat_url (HEARTBEAT_URL) must be an http(s) url")
+	}
+
+	if len(c.Admin.Email) > 0 && (c.SMTP.Address == "" || c.SMTP.From == "") {
+		problem("smtp.address (SMTP_ADDRESS) and smtp.from (SMTP_FROM) are required to email admin.email (ADMIN_EMAIL)")
+	}
+
+	if c.Notify.Workers < 1 {
+		problem("notify.workers (NOTIFY_WORKERS) must be at least 1")
+	}
+	if c.Notify.MaxAttempts < 1 {
+		problem("notify.max_attempts (NOTIFY_MAX_ATTEMPTS) must be at least 1")
+	}
+	if c.Pipeline.ListWorkers < 1 || c.Pipeline.StatsWorkers < 1 || c.Pipeline.ScrapeWorkers < 1 {
+		problem("pipeline.list_workers, pipeline.stats_workers and pipeline.scrape_workers (PIPELINE_*_WORKERS) must be at least 1")
+	}
+	if c.Notify.QuarantineAfter < 1 {
+		problem("notify.quarantine_after (NOTIFY_QUARANTINE_AFTER) must be at least 1")
+	}
+
+	durations := []struct {
+		Name     string
+		Duration Duration
+	}{
+		{"smtp.timeout (SMTP_TIMEOUT)", c.SMTP.Timeout},
+		{"notify.cooldown (NOTIFY_COOLDOWN)", c.Notify.Cooldown},
+		{"notify.retry_base (NOTIFY_RETRY_BASE)", c.Notify.RetryBase},
+		{"notify.retry_max", c.Notify.RetryMax},
+		{"notify.quarantine_for (NOTIFY_QUARANTINE_FOR)", c.Notify.QuarantineFor},
+		{"job.timeout (JOB_TIMEOUT)", c.Job.Timeout},
+		{"job.flush_timeout (JOB_FLUSH_TIMEOUT)", c.Job.FlushTimeout},
+		{"health.stall_threshold (STALL_THRESHOLD)", c.Health.StallThreshold},
+		{"release_cache_ttl (RELEASE_CACHE_TTL)", c.ReleaseCacheTTL},
+		{"price_history (PRICE_HISTORY)", c.PriceHistory},
+	}
+	for _, d := range durations {
+		if d.Duration < 0 {
+			problem("%s must not be negative", d.Name)
+		}
+	}
+
+	if c.DataDir == "" {
+		problem("data_dir (DATA_DIR) is required")
+	}
+
+	if c.TemplateDir != "" {
+		if info, err := os.Stat(c.TemplateDir); err != nil || !info.IsDir() {
+			problem("template_dir (TEMPLATE_DIR) %s is not a directory", c.TemplateDir)
+		}
+	}
+
+	if c.Scraper.MaxPages < 1 {
+		problem("scraper.max_pages (SCRAPER_MAX_PAGES) must be at least 1")
+	}
+
+	if _, err := LoadSelectorProfile(c.Scraper.Selectors); err != nil {
+		problem("scraper.selectors (SCRAPER_SELECTORS): %v", err)
+	}
+
+	if c.Imports.Country != "" {
+		if _, ok := CountryCode(c.Imports.Country); !ok {
+			problem("imports.country (IMPORT_COUNTRY) '%s' is not a known country", c.Imports.Country)
+		}
+	} else if len(c.Imports.Charges) > 0 {
+		problem("imports.country (IMPORT_COUNTRY) is required for imports.charges")
+	}
+
+	for i, charge := range c.Imports.Charges {
+		for _, location := range append(append([]string{}, charge.From...), charge.To...) {
+			if _, err := ParseLocation(location); err != nil {
+				problem("imports.charges[%d]: %v", i, err)
+			}
+		}
+		if charge.Duty < 0 || charge.DutyThreshold < 0 || charge.VAT < 0 || charge.VATThreshold < 0 || charge.Fee < 0 {
+			problem("imports.charges[%d] must not be negative", i)
+		}
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		problem("port (PORT) %d is not a valid port", c.Port)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// redacted is shown in place of secrets
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the config with secrets replaced so it is
+// safe to print
+func (c Config) Redacted() Config {
+	redact := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return redacted
+	}
+
+	c.Discogs.Token = redact(c.Discogs.Token)
+	c.SMTP.Password = redact(c.SMTP.Password)
+	c.Chat.WebhookURL = redact(c.Chat.WebhookURL)
+	c.Health.HeartbeatURL = redact(c.Health.HeartbeatURL)
+
+	return c
+}
+
+// YAML returns the config as yaml
+func (c Config) YAML() (string, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
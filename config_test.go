@@ -0,0 +1,188 @@
+package notifier
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// clearConfigEnv unsets every environment variable which overrides config
+// for the duration of a test
+func clearConfigEnv(t *testing.T) {
+	config := DefaultConfig()
+	for _, override := range config.envOverrides() {
+		value, ok := os.LookupEnv(override.Key)
+		os.Unsetenv(override.Key)
+		if ok {
+			key := override.Key
+			t.Cleanup(func() { os.Setenv(key, value) })
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	clearConfigEnv(t)
+
+	path := writeConfigFile(t, `
+discogs:
+  username: digger
+  token: secret-token
+  currency: aud
+smtp:
+  address: smtp.example.com
+  port: 465
+  username: notifier@example.com
+  password: secret-password
+  to:
+    - user@example.com
+notify:
+  cooldown: 1h
+`)
+
+	os.Setenv("NOTIFY_WORKERS", "4")
+	os.Setenv("USER_BCC", "a@example.com, b@example.com")
+	defer os.Unsetenv("NOTIFY_WORKERS")
+	defer os.Unsetenv("USER_BCC")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values from the file
+	if config.Discogs.Username != "digger" || time.Duration(config.Notify.Cooldown) != time.Hour {
+		t.Errorf("Expected values from config file, got %+v", config)
+	}
+
+	// Values from the environment
+	if config.Notify.Workers != 4 {
+		t.Errorf("Expected workers from environment, got %d", config.Notify.Workers)
+	}
+	if expected := []string{"a@example.com", "b@example.com"}; !cmp.Equal(config.SMTP.Bcc, expected) {
+		t.Errorf("Expected bcc %v from environment, got %v", expected, config.SMTP.Bcc)
+	}
+
+	// Defaults and derived values
+	if config.Notify.MaxAttempts != 8 || config.DataDir != "data" {
+		t.Errorf("Expected default values, got %+v", config)
+	}
+	if config.Discogs.Currency != "AUD" {
+		t.Errorf("Expected normalised currency AUD, got %s", config.Discogs.Currency)
+	}
+	if config.SMTP.TLSMode != TLSModeImplicit || config.SMTP.Auth != AuthPlain {
+		t.Errorf("Expected implicit TLS with plain auth, got %s %s", config.SMTP.TLSMode, config.SMTP.Auth)
+	}
+	if config.SMTP.From != "notifier@example.com" {
+		t.Errorf("Expected from to default to username, got %s", config.SMTP.From)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	clearConfigEnv(t)
+
+	if _, err := LoadConfig(writeConfigFile(t, "discogs:\n  usrname: typo\n")); err == nil {
+		t.Error("Expected error for unknown setting")
+	}
+
+	if _, err := LoadConfig(writeConfigFile(t, "notify:\n  cooldown: soon\n")); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+
+	os.Setenv("NOTIFY_WORKERS", "many")
+	defer os.Unsetenv("NOTIFY_WORKERS")
+
+	if _, err := LoadConfig(""); err == nil || !strings.Contains(err.Error(), "NOTIFY_WORKERS") {
+		t.Errorf("Expected error naming NOTIFY_WORKERS, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	clearConfigEnv(t)
+
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Discogs.Currency = "XYZ"
+	config.SMTP.To = []string{"user@example.com"}
+	config.SMTP.Auth = AuthLogin
+	config.Notify.Workers = 0
+	config.Imports.Charges = []ImportCharge{{From: []string{"Atlantis"}, VAT: -1}}
+
+	err = config.Validate()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"discogs.username",
+		"discogs.token",
+		"discogs.currency",
+		"smtp.address",
+		"smtp.from",
+		"login auth",
+		"notify.workers",
+		"imports.country",
+		"unknown country or region 'Atlantis'",
+		"imports.charges[0] must not be negative",
+	}
+
+	if len(validationErr.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %v", len(expected), validationErr.Problems)
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("Expected problem mentioning '%s', got:\n%v", e, err)
+		}
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	config := DefaultConfig()
+	config.Discogs.Token = "secret-token"
+	config.SMTP.Password = "secret-password"
+	config.Chat.WebhookURL = "https://hooks.example.com/secret-hook"
+
+	out, err := config.Redacted().YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"secret-token", "secret-password", "secret-hook"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Expected %s to be redacted, got:\n%s", secret, out)
+		}
+	}
+
+	if !strings.Contains(out, "cooldown: 30m0s") {
+		t.Errorf("Expected durations to be written as strings, got:\n%s", out)
+	}
+
+	// The original config must be unchanged
+	if config.Discogs.Token != "secret-token" {
+		t.Error("Expected Redacted to return a copy")
+	}
+}
@@ -0,0 +1,167 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+)
+
+// countryNames maps ISO 3166-1 alpha-2 codes to the names sellers' ships
+// from locations are written as on discogs, followed by any other names
+// they're known by
+var countryNames = map[string][]string{
+	"AD": {"Andorra"},
+	"AL": {"Albania"},
+	"AR": {"Argentina"},
+	"AT": {"Austria"},
+	"AU": {"Australia"},
+	"BA": {"Bosnia and Herzegovina", "Bosnia & Herzegovina"},
+	"BE": {"Belgium"},
+	"BG": {"Bulgaria"},
+	"BR": {"Brazil"},
+	"BY": {"Belarus"},
+	"CA": {"Canada"},
+	"CH": {"Switzerland"},
+	"CL": {"Chile"},
+	"CN": {"China"},
+	"CO": {"Colombia"},
+	"CR": {"Costa Rica"},
+	"CY": {"Cyprus"},
+	"CZ": {"Czech Republic", "Czechia"},
+	"DE": {"Germany"},
+	"DK": {"Denmark"},
+	"EC": {"Ecuador"},
+	"EE": {"Estonia"},
+	"EG": {"Egypt"},
+	"ES": {"Spain"},
+	"FI": {"Finland"},
+	"FR": {"France"},
+	"GB": {"UK", "United Kingdom", "Great Britain", "England", "Scotland", "Wales", "Northern Ireland"},
+	"GE": {"Georgia"},
+	"GR": {"Greece"},
+	"HK": {"Hong Kong"},
+	"HR": {"Croatia"},
+	"HU": {"Hungary"},
+	"ID": {"Indonesia"},
+	"IE": {"Ireland"},
+	"IL": {"Israel"},
+	"IN": {"India"},
+	"IS": {"Iceland"},
+	"IT": {"Italy"},
+	"JP": {"Japan"},
+	"KR": {"South Korea", "Korea, Republic of", "Korea"},
+	"LI": {"Liechtenstein"},
+	"LT": {"Lithuania"},
+	"LU": {"Luxembourg"},
+	"LV": {"Latvia"},
+	"MA": {"Morocco"},
+	"MC": {"Monaco"},
+	"MD": {"Moldova", "Moldova, Republic of"},
+	"ME": {"Montenegro"},
+	"MK": {"North Macedonia", "Macedonia"},
+	"MT": {"Malta"},
+	"MX": {"Mexico"},
+	"MY": {"Malaysia"},
+	"NL": {"Netherlands", "The Netherlands", "Holland"},
+	"NO": {"Norway"},
+	"NZ": {"New Zealand"},
+	"PE": {"Peru"},
+	"PH": {"Philippines"},
+	"PL": {"Poland"},
+	"PR": {"Puerto Rico"},
+	"PT": {"Portugal"},
+	"RO": {"Romania"},
+	"RS": {"Serbia"},
+	"RU": {"Russia", "Russian Federation"},
+	"SE": {"Sweden"},
+	"SG": {"Singapore"},
+	"SI": {"Slovenia"},
+	"SK": {"Slovakia"},
+	"TH": {"Thailand"},
+	"TR": {"Turkey", "Türkiye"},
+	"TW": {"Taiwan"},
+	"UA": {"Ukraine"},
+	"US": {"United States", "USA", "United States of America"},
+	"UY": {"Uruguay"},
+	"VE": {"Venezuela"},
+	"VN": {"Vietnam", "Viet Nam"},
+	"ZA": {"South Africa"},
+}
+
+// regions group countries so rules can allow or deny many at once
+var regions = map[string][]string{
+	"EU":           {"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK"},
+	"UK":           {"GB"},
+	"Europe":       {"AD", "AL", "AT", "BA", "BE", "BG", "BY", "CH", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GB", "GR", "HR", "HU", "IE", "IS", "IT", "LI", "LT", "LU", "LV", "MC", "MD", "ME", "MK", "MT", "NL", "NO", "PL", "PT", "RO", "RS", "RU", "SE", "SI", "SK", "UA"},
+	"NorthAmerica": {"CA", "MX", "PR", "US"},
+	"SouthAmerica": {"AR", "BR", "CL", "CO", "EC", "PE", "UY", "VE"},
+	"Asia":         {"CN", "HK", "ID", "IN", "JP", "KR", "MY", "PH", "SG", "TH", "TW", "VN"},
+	"Oceania":      {"AU", "NZ"},
+}
+
+// normaliseName folds case, spacing and punctuation so names match
+// however they're written e.g. 'north america' and 'NorthAmerica'
+func normaliseName(name string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "", ".", "").Replace(strings.ToLower(name))
+}
+
+// countryCodes maps the normalised names of countries to their codes
+var countryCodes = func() map[string]string {
+	codes := map[string]string{}
+	for code, names := range countryNames {
+		for _, name := range names {
+			codes[normaliseName(name)] = code
+		}
+	}
+
+	return codes
+}()
+
+// CountryCode returns the ISO 3166-1 alpha-2 code of a country from its
+// name (as written in ships from locations) or code, or false if the
+// country is unknown
+func CountryCode(location string) (string, bool) {
+	location = strings.TrimSpace(location)
+
+	if _, ok := countryNames[strings.ToUpper(location)]; ok && len(location) == 2 {
+		return strings.ToUpper(location), true
+	}
+
+	code, ok := countryCodes[normaliseName(location)]
+
+	return code, ok
+}
+
+// ParseLocation returns the canonical form of a region or country used in
+// rules, a region's name or a country code. Regions take precedence so
+// 'UK' is the region rather than a name of GB
+func ParseLocation(location string) (string, error) {
+	for region := range regions {
+		if normaliseName(region) == normaliseName(location) {
+			return region, nil
+		}
+	}
+
+	if code, ok := CountryCode(location); ok {
+		return code, nil
+	}
+
+	return "", fmt.Errorf("unknown country or region '%s'", location)
+}
+
+// InLocations returns whether a country code is one of, or in a region
+// of, locations given in their canonical form
+func InLocations(country string, locations []string) bool {
+	for _, location := range locations {
+		if location == country {
+			return true
+		}
+
+		for _, member := range regions[location] {
+			if member == country {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"testing"
+)
+
+func TestCountryCode(t *testing.T) {
+	cases := map[string]string{
+		"Germany":            "DE",
+		"germany ":           "DE",
+		"United States":      "US",
+		"UK":                 "GB",
+		"Czechia":            "CZ",
+		"Korea, Republic of": "KR",
+		"jp":                 "JP",
+	}
+
+	for location, expected := range cases {
+		code, ok := CountryCode(location)
+		if !ok || code != expected {
+			t.Errorf("Expected %s for '%s', got '%s'", expected, location, code)
+		}
+	}
+
+	for _, location := range []string{"", "Atlantis", "XX"} {
+		if code, ok := CountryCode(location); ok {
+			t.Errorf("Expected '%s' to be unknown, got %s", location, code)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	cases := map[string]string{
+		"eu":            "EU",
+		"UK":            "UK",
+		"North America": "NorthAmerica",
+		"france":        "FR",
+		"NL":            "NL",
+	}
+
+	for location, expected := range cases {
+		parsed, err := ParseLocation(location)
+		if err != nil || parsed != expected {
+			t.Errorf("Expected %s for '%s', got '%s' (%v)", expected, location, parsed, err)
+		}
+	}
+
+	if _, err := ParseLocation("Narnia"); err == nil {
+		t.Error("Expected error for unknown location")
+	}
+}
+
+func TestInLocations(t *testing.T) {
+	if !InLocations("GB", []string{"UK"}) || !InLocations("DE", []string{"US", "EU"}) {
+		t.Error("Expected countries in a region to be in locations")
+	}
+
+	if InLocations("GB", []string{"EU"}) || InLocations("US", nil) {
+		t.Error("Expected countries outside locations not to be in them")
+	}
+}
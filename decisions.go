@@ -0,0 +1,418 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RuleResult is the outcome of checking a single rule
+type RuleResult struct {
+	Rule   string `json:"rule"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason"`
+}
+
+// Passed returns whether every rule passed
+func Passed(results []RuleResult) bool {
+	for _, result := range results {
+		if !result.Passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EvaluateItem checks the rules for notifying of a market item given its
+// previous stats (nil if it hasn't been seen before)
+func EvaluateItem(item MarketItem, previous *MarketItem) []RuleResult {
+	if previous == nil {
+		return []RuleResult{{
+			Rule:   "previous_stats",
+			Passed: false,
+			Reason: "first time seen, no previous stats to compare",
+		}}
+	}
+
+	results := []RuleResult{}
+
+	// Check if number of items for sale has increased
+	forSale := RuleResult{
+		Rule:   "num_for_sale",
+		Passed: item.NumForSale > previous.NumForSale,
+		Reason: fmt.Sprintf("%d for sale, previously %d", item.NumForSale, previous.NumForSale),
+	}
+	results = append(results, forSale)
+
+	// Check if a minimum price threshold is set and if the lowest price meets it
+	// Without a max price, no listing can meet the condition tiers if the
+	// lowest price is over the highest of them
+	max := item.MinimumPrice
+	if max == 0 {
+		max = item.Rules.HighestConditionPrice()
+	}
+
+	price := RuleResult{Rule: "max_price", Passed: true, Reason: "no max price set"}
+	if max > 0 {
+		price.Passed = item.LowestPrice <= max
+		price.Reason = fmt.Sprintf("lowest price %.2f, max %.2f", item.LowestPrice, max)
+	}
+	results = append(results, price)
+
+	// Check the lowest price against the market's recent prices
+	if percent := item.Rules.MedianPercent; percent > 0 {
+		median := RuleResult{Rule: "median_price", Passed: false, Reason: "no price history"}
+		if item.MedianLowestPrice > 0 {
+			max := item.MedianLowestPrice * percent / 100
+			median.Passed = item.LowestPrice <= max
+			median.Reason = fmt.Sprintf("lowest price %.2f, max %.2f (%g%% of median %.2f)", item.LowestPrice, max, percent, item.MedianLowestPrice)
+		}
+		results = append(results, median)
+	}
+
+	// Nothing was previously for sale, so any price is a drop
+	if percent := item.Rules.DropPercent; percent > 0 {
+		drop := RuleResult{Rule: "price_drop", Passed: true, Reason: "no previous lowest price"}
+		if previous.LowestPrice > 0 {
+			max := previous.LowestPrice * (1 - percent/100)
+			drop.Passed = item.LowestPrice <= max
+			drop.Reason = fmt.Sprintf("lowest price %.2f, max %.2f (%g%% below previous %.2f)", item.LowestPrice, max, percent, previous.LowestPrice)
+		}
+		results = append(results, drop)
+	}
+
+	if item.Rules.HasReleaseRules() {
+		results = append(results, releaseRule(item))
+	}
+
+	return results
+}
+
+// releaseRule checks the format, genres and year of an item's release
+func releaseRule(item MarketItem) RuleResult {
+	result := RuleResult{Rule: "release", Passed: false}
+	rules := item.Rules
+
+	release := item.Release
+	if release == nil {
+		result.Reason = "no release metadata to check"
+		return result
+	}
+
+	if len(rules.Formats) > 0 && !anyOf(rules.Formats, release.HasFormat) {
+		result.Reason = fmt.Sprintf("format %s, wanted %s", release.FormatDescription(), strings.Join(rules.Formats, ","))
+		return result
+	}
+
+	if len(rules.Genres) > 0 && !anyOf(rules.Genres, release.HasGenre) {
+		genres := append(append([]string{}, release.Genres...), release.Styles...)
+		result.Reason = fmt.Sprintf("genres %s, wanted %s", strings.Join(genres, ", "), strings.Join(rules.Genres, ","))
+		return result
+	}
+
+	if (rules.MinYear > 0 || rules.MaxYear > 0) && release.Year == 0 {
+		result.Reason = "release year unknown"
+		return result
+	}
+	if rules.MinYear > 0 && release.Year < rules.MinYear {
+		result.Reason = fmt.Sprintf("released %d, min %d", release.Year, rules.MinYear)
+		return result
+	}
+	if rules.MaxYear > 0 && release.Year > rules.MaxYear {
+		result.Reason = fmt.Sprintf("released %d, max %d", release.Year, rules.MaxYear)
+		return result
+	}
+
+	result.Passed = true
+	result.Reason = release.FormatDescription()
+	if release.Year > 0 {
+		result.Reason += fmt.Sprintf(" released %d", release.Year)
+	}
+
+	return result
+}
+
+// anyOf returns whether match is true for any of the values
+func anyOf(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shipsFromRule checks where a listing ships from against the allowed and
+// denied locations. Listings from unknown countries only pass if no
+// locations are allowed
+func shipsFromRule(listing ListedItem, rules Rules) RuleResult {
+	result := RuleResult{Rule: "ships_from", Passed: true, Reason: fmt.Sprintf("ships from %s", listing.Location)}
+
+	if listing.Country == "" {
+		if len(rules.ShipsFrom) > 0 {
+			result.Passed = false
+			result.Reason = fmt.Sprintf("ships from unknown country '%s', allowed %s", listing.Location, strings.Join(rules.ShipsFrom, ","))
+		}
+		return result
+	}
+
+	if len(rules.ShipsFrom) > 0 && !InLocations(listing.Country, rules.ShipsFrom) {
+		result.Passed = false
+		result.Reason = fmt.Sprintf("ships from %s, allowed %s", listing.Country, strings.Join(rules.ShipsFrom, ","))
+	}
+
+	if InLocations(listing.Country, rules.NotShipsFrom) {
+		result.Passed = false
+		result.Reason = fmt.Sprintf("ships from %s, denied %s", listing.Country, strings.Join(rules.NotShipsFrom, ","))
+	}
+
+	return result
+}
+
+// conditionPriceRule checks the price of a listing against the maximum
+// price for its media and sleeve conditions
+func conditionPriceRule(listing ListedItem, rules Rules) RuleResult {
+	result := RuleResult{Rule: "condition_price"}
+	price := float64(listing.Price) / 100
+
+	max, ok := rules.ConditionMaxPrice(listing.MediaCondition, listing.SleeveCondition)
+	if !ok {
+		result.Reason = fmt.Sprintf("no max price for %s media", listing.MediaCondition.Abbreviation())
+		return result
+	}
+
+	result.Passed = price <= max
+	result.Reason = fmt.Sprintf("price %.2f, max %.2f for %s media and %s sleeve", price, max, listing.MediaCondition.Abbreviation(), listing.SleeveCondition.Abbreviation())
+
+	return result
+}
+
+// suggestedPriceRule checks the price of a listing against discogs'
+// suggested price for its media condition
+func suggestedPriceRule(listing ListedItem, item MarketItem) RuleResult {
+	result := RuleResult{Rule: "suggested_price"}
+	percent := item.Rules.SuggestedPercent
+
+	suggestion, ok := item.PriceSuggestions[listing.MediaCondition]
+	if !ok {
+		result.Reason = fmt.Sprintf("no price suggestion for %s media", listing.MediaCondition.Abbreviation())
+		return result
+	}
+
+	// Suggestions are in the user's seller currency which can differ
+	if suggestion.Currency != item.Currency {
+		result.Reason = fmt.Sprintf("price suggestion in %s, prices in %s", suggestion.Currency, item.Currency)
+		return result
+	}
+
+	price := float64(listing.Price) / 100
+	max := suggestion.Value * percent / 100
+
+	result.Passed = price <= max
+	result.Reason = fmt.Sprintf("price %.2f, max %.2f (%g%% of suggested %.2f for %s media)", price, max, percent, suggestion.Value, listing.MediaCondition.Abbreviation())
+
+	return result
+}
+
+// commentRule checks a listing's comment contains any of the keywords or
+// matches the pattern of the rules, and none of those excluded
+func commentRule(listing ListedItem, rules Rules) RuleResult {
+	result := RuleResult{Rule: "comment", Passed: true, Reason: "comment matches keywords"}
+
+	for _, keyword := range rules.NotKeywords {
+		if keywordPattern(keyword).MatchString(listing.Comment) {
+			result.Passed = false
+			result.Reason = fmt.Sprintf("comment contains excluded '%s'", keyword)
+			return result
+		}
+	}
+
+	if rules.NotMatch != "" {
+		pattern, err := compileCommentPattern(rules.NotMatch)
+		if err != nil {
+			result.Passed = false
+			result.Reason = fmt.Sprintf("invalid excluded pattern '%s'", rules.NotMatch)
+			return result
+		}
+		if pattern.MatchString(listing.Comment) {
+			result.Passed = false
+			result.Reason = fmt.Sprintf("comment matches excluded pattern '%s'", rules.NotMatch)
+			return result
+		}
+	}
+
+	for _, keyword := range rules.Keywords {
+		if keywordPattern(keyword).MatchString(listing.Comment) {
+			result.Reason = fmt.Sprintf("comment contains '%s'", keyword)
+			return result
+		}
+	}
+
+	if rules.Match != "" {
+		pattern, err := compileCommentPattern(rules.Match)
+		if err != nil {
+			result.Passed = false
+			result.Reason = fmt.Sprintf("invalid pattern '%s'", rules.Match)
+			return result
+		}
+		if pattern.MatchString(listing.Comment) {
+			result.Reason = fmt.Sprintf("comment matches pattern '%s'", rules.Match)
+			return result
+		}
+	}
+
+	// Without included keywords or a pattern every comment not excluded passes
+	if len(rules.Keywords) > 0 || rules.Match != "" {
+		result.Passed = false
+		result.Reason = "comment doesn't contain any included keyword or pattern"
+	}
+
+	return result
+}
+
+// EvaluateListing checks the rules for notifying of a listing of a market
+// item
+func EvaluateListing(listing ListedItem, item MarketItem) []RuleResult {
+	price := RuleResult{Rule: "max_price", Passed: true, Reason: "no max price set"}
+
+	if item.MinimumPrice > 0 {
+		// Listed prices are scraped in cents
+		listingPrice := float64(listing.Price) / 100
+
+		price.Passed = listingPrice <= item.MinimumPrice
+		price.Reason = fmt.Sprintf("price %.2f, max %.2f", listingPrice, item.MinimumPrice)
+	}
+
+	available := RuleResult{Rule: "available", Passed: !listing.Unavailable, Reason: "ships to your country"}
+	if listing.Unavailable {
+		available.Reason = "doesn't ship to your country"
+	}
+
+	results := []RuleResult{available, price}
+
+	if max := item.Rules.MaxLanded; max > 0 {
+		landed := float64(listing.Landed.Total()) / 100
+		results = append(results, RuleResult{
+			Rule:   "max_landed",
+			Passed: landed <= max,
+			Reason: fmt.Sprintf("landed cost %.2f (%.2f import charges), max %.2f", landed, float64(listing.Landed.Charges())/100, max),
+		})
+	}
+
+	if len(item.Rules.MaxByMedia) > 0 {
+		results = append(results, conditionPriceRule(listing, item.Rules))
+	}
+
+	if item.Rules.SuggestedPercent > 0 {
+		results = append(results, suggestedPriceRule(listing, item))
+	}
+
+	if min := item.Rules.MinMedia; min != GradeNotGraded {
+		results = append(results, RuleResult{
+			Rule:   "min_media",
+			Passed: listing.MediaCondition.AtLeast(min),
+			Reason: fmt.Sprintf("media %s, min %s", listing.MediaCondition.Abbreviation(), min.Abbreviation()),
+		})
+	}
+
+	if min := item.Rules.MinSleeve; min != GradeNotGraded {
+		results = append(results, RuleResult{
+			Rule:   "min_sleeve",
+			Passed: listing.SleeveCondition.AtLeast(min),
+			Reason: fmt.Sprintf("sleeve %s, min %s", listing.SleeveCondition.Abbreviation(), min.Abbreviation()),
+		})
+	}
+
+	if len(item.Rules.ShipsFrom) > 0 || len(item.Rules.NotShipsFrom) > 0 {
+		results = append(results, shipsFromRule(listing, item.Rules))
+	}
+
+	if item.Rules.HasCommentRules() {
+		results = append(results, commentRule(listing, item.Rules))
+	}
+
+	return results
+}
+
+// ListingsRule checks an item can be notified given the listings matching
+// its rules, out of the number of listings scraped. Rules only listings
+// can be checked against need a matching listing, as do scraped listings,
+// but if nothing new was scraped the lowest price is notified instead
+func ListingsRule(item MarketItem, listings int, scraped bool) RuleResult {
+	result := RuleResult{Rule: "listings", Passed: true, Reason: fmt.Sprintf("%d of %d listings match", len(item.Listings), listings)}
+	if len(item.Listings) > 0 {
+		return result
+	}
+
+	switch {
+	case !scraped && item.Rules.HasListingRules():
+		result.Passed = false
+		result.Reason = "listings couldn't be scraped to check the listing rules"
+	case !scraped:
+		result.Reason = "listings couldn't be scraped, notifying the lowest price"
+	case item.Rules.HasListingRules():
+		result.Passed = false
+		result.Reason = "no listing satisfies the listing rules"
+	case listings > 0:
+		result.Passed = false
+		result.Reason = fmt.Sprintf("none of %d listings satisfy the rules", listings)
+	default:
+		result.Reason = "no new listings, notifying the lowest price"
+	}
+
+	return result
+}
+
+// Decision records the rules checked for an item, one of its listings or a
+// notification to a recipient and whether it would be notified
+type Decision struct {
+	Time      time.Time    `json:"time"`
+	ReleaseID int          `json:"release_id"`
+	Name      string       `json:"name"`
+	ListingID string       `json:"listing_id,omitempty"`
+	Recipient string       `json:"recipient,omitempty"`
+	Notify    bool         `json:"notify"`
+	Rules     []RuleResult `json:"rules"`
+}
+
+// DecisionLog appends decisions to a JSON lines file
+type DecisionLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewDecisionLog creates a DecisionLog writing to path
+func NewDecisionLog(path string) *DecisionLog {
+	return &DecisionLog{path: path}
+}
+
+// Write appends the decisions to the log, one JSON object per line
+func (l *DecisionLog) Write(decisions ...Decision) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	for _, decision := range decisions {
+		if err := encoder.Encode(decision); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	return f.Close()
+}
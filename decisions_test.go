@@ -0,0 +1,355 @@
+package notifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvaluateItem(t *testing.T) {
+	item := MarketItem{NumForSale: 11, LowestPrice: 30, MinimumPrice: 25}
+
+	results := EvaluateItem(item, nil)
+	if Passed(results) || results[0].Rule != "previous_stats" {
+		t.Errorf("Expected first seen item to fail previous_stats, got %v", results)
+	}
+
+	results = EvaluateItem(item, &MarketItem{NumForSale: 10})
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 rule results, got %v", results)
+	}
+
+	if !results[0].Passed || results[0].Rule != "num_for_sale" {
+		t.Errorf("Expected num_for_sale to pass, got %v", results[0])
+	}
+
+	if results[1].Passed || results[1].Reason != "lowest price 30.00, max 25.00" {
+		t.Errorf("Expected max_price to fail with reason, got %v", results[1])
+	}
+
+	item.MinimumPrice = 0
+	if !Passed(EvaluateItem(item, &MarketItem{NumForSale: 10})) {
+		t.Error("Expected item with no max price to pass")
+	}
+
+	item.Rules = Rules{MaxByMedia: map[Grading]float64{GradeNearMint: 28, GradeVeryGood: 15}}
+	if Passed(EvaluateItem(item, &MarketItem{NumForSale: 10})) {
+		t.Error("Expected item with lowest price over every condition tier to fail")
+	}
+
+	item.Rules = Rules{MedianPercent: 80}
+	if Passed(EvaluateItem(item, &MarketItem{NumForSale: 10})) {
+		t.Error("Expected item without price history to fail a median rule")
+	}
+
+	item.MedianLowestPrice = 37.5
+	if !Passed(EvaluateItem(item, &MarketItem{NumForSale: 10})) {
+		t.Error("Expected item at 80% of the median lowest price to pass")
+	}
+
+	item.MedianLowestPrice = 35
+	if Passed(EvaluateItem(item, &MarketItem{NumForSale: 10})) {
+		t.Error("Expected item over 80% of the median lowest price to fail")
+	}
+
+	item.Rules = Rules{DropPercent: 10}
+	if !Passed(EvaluateItem(item, &MarketItem{NumForSale: 10, LowestPrice: 34})) {
+		t.Error("Expected item 10% below the previous lowest price to pass")
+	}
+
+	results = EvaluateItem(item, &MarketItem{NumForSale: 10, LowestPrice: 32})
+	if Passed(results) || results[2].Reason != "lowest price 30.00, max 28.80 (10% below previous 32.00)" {
+		t.Errorf("Expected item less than 10%% below the previous lowest price to fail with reason, got %v", results)
+	}
+
+	item = MarketItem{NumForSale: 11, Rules: mustParseRules(t, "format=lp genre=ambient year>=1990 year<=1995")}
+	if Passed(EvaluateItem(item, &MarketItem{NumForSale: 10})) {
+		t.Error("Expected item without release metadata to fail release rules")
+	}
+
+	release := testRelease()
+	item.Release = &release
+	if results := EvaluateItem(item, &MarketItem{NumForSale: 10}); !Passed(results) {
+		t.Errorf("Expected release matching format, genre and year to pass, got %v", results)
+	}
+
+	release.Year = 1996
+	if results := EvaluateItem(item, &MarketItem{NumForSale: 10}); Passed(results) || results[2].Reason != "released 1996, max 1995" {
+		t.Errorf("Expected release after the max year to fail with reason, got %v", results)
+	}
+
+	item.Rules.Formats = []string{"CD"}
+	if Passed(EvaluateItem(item, &MarketItem{NumForSale: 10})) {
+		t.Error("Expected release in another format to fail")
+	}
+}
+
+// mustParseRules parses rules, compiling their comment patterns
+func mustParseRules(t *testing.T, text string) Rules {
+	rules, err := ParseRules(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rules
+}
+
+func TestEvaluateListing(t *testing.T) {
+	item := MarketItem{MinimumPrice: 25}
+
+	if !Passed(EvaluateListing(ListedItem{Price: 2500}, item)) {
+		t.Error("Expected listing at max price to pass")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Price: 2501}, item)) {
+		t.Error("Expected listing over max price to fail")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Price: 2000, Unavailable: true}, item)) {
+		t.Error("Expected listing unavailable in the user's country to fail")
+	}
+
+	item = MarketItem{Rules: Rules{MaxLanded: 30}}
+
+	if !Passed(EvaluateListing(ListedItem{Landed: LandedCost{Price: 2000, Shipping: 1000}}, item)) {
+		t.Error("Expected listing at max landed cost to pass")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Landed: LandedCost{Price: 2000, Shipping: 800, VAT: 560}}, item)) {
+		t.Error("Expected listing over max landed cost with import charges to fail")
+	}
+
+	item.Rules = Rules{
+		MaxByMedia:        map[Grading]float64{GradeNearMint: 45, GradeVeryGoodPlus: 30},
+		SleeveAdjustments: map[Grading]float64{GradeVeryGood: -5},
+	}
+
+	if !Passed(EvaluateListing(ListedItem{Price: 4000, MediaCondition: GradeMint, SleeveCondition: GradeNearMint}, item)) {
+		t.Error("Expected mint listing under the NM tier to pass")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Price: 2800, MediaCondition: GradeVeryGoodPlus, SleeveCondition: GradeVeryGood}, item)) {
+		t.Error("Expected VG+ listing over the tier adjusted for a VG sleeve to fail")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Price: 500, MediaCondition: GradeGood}, item)) {
+		t.Error("Expected listing below every condition tier to fail")
+	}
+
+	item = MarketItem{
+		Currency: "AUD",
+		Rules:    Rules{SuggestedPercent: 90},
+		PriceSuggestions: map[Grading]LowestPrice{
+			GradeNearMint:     {Currency: "AUD", Value: 40},
+			GradeVeryGoodPlus: {Currency: "USD", Value: 30},
+		},
+	}
+
+	if !Passed(EvaluateListing(ListedItem{Price: 3600, MediaCondition: GradeNearMint}, item)) {
+		t.Error("Expected listing at 90% of its suggested price to pass")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Price: 3700, MediaCondition: GradeNearMint}, item)) {
+		t.Error("Expected listing over 90% of its suggested price to fail")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Price: 100, MediaCondition: GradeVeryGoodPlus}, item)) {
+		t.Error("Expected listing with a suggestion in another currency to fail")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Price: 100, MediaCondition: GradeGood}, item)) {
+		t.Error("Expected listing without a suggestion for its condition to fail")
+	}
+
+	item = MarketItem{Rules: Rules{Keywords: []string{"sealed", "promo"}, NotKeywords: []string{"warped", "no inner sleeve"}}}
+
+	cases := map[string]bool{
+		"Still SEALED in shrink":        true,
+		"White label promo, plays fine": true,
+		"Unsealed, opened once":         false,
+		"Sealed but slightly warped":    false,
+		"Promo copy. No inner sleeve.":  false,
+		"":                              false,
+	}
+	for comment, expected := range cases {
+		if passed := Passed(EvaluateListing(ListedItem{Comment: comment}, item)); passed != expected {
+			t.Errorf("Expected comment '%s' to pass %t, got %t", comment, expected, passed)
+		}
+	}
+
+	item.Rules = Rules{Match: `shrink.?wrap`, NotMatch: `skip(s|ping)?`}
+
+	if !Passed(EvaluateListing(ListedItem{Comment: "Still in Shrink-wrap"}, item)) {
+		t.Error("Expected comment matching pattern to pass")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Comment: "In shrinkwrap, skips on side B"}, item)) {
+		t.Error("Expected comment matching excluded pattern to fail")
+	}
+
+	item.Rules = Rules{NotKeywords: []string{"VG+"}}
+
+	if !Passed(EvaluateListing(ListedItem{}, item)) || Passed(EvaluateListing(ListedItem{Comment: "sleeve is vg+"}, item)) {
+		t.Error("Expected excluded keywords to only fail comments containing them")
+	}
+
+	item.Rules = Rules{}.Merge(mustParseRules(t, "!has=warped"))
+
+	if Passed(EvaluateListing(ListedItem{Comment: "Slightly warped"}, item)) {
+		t.Error("Expected merged comment rules to be applied")
+	}
+
+	item.Rules = Rules{Match: "(unclosed"}
+
+	if result := commentRule(ListedItem{Comment: "(unclosed"}, item.Rules); result.Passed {
+		t.Error("Expected an invalid pattern to fail")
+	}
+
+	item.Rules = Rules{MinMedia: GradeVeryGoodPlus, MinSleeve: GradeVeryGood}
+
+	if !Passed(EvaluateListing(ListedItem{MediaCondition: GradeNearMint, SleeveCondition: GradeVeryGood}, item)) {
+		t.Error("Expected listing meeting minimum conditions to pass")
+	}
+
+	if Passed(EvaluateListing(ListedItem{MediaCondition: GradeVeryGood, SleeveCondition: GradeMint}, item)) {
+		t.Error("Expected listing below minimum media condition to fail")
+	}
+
+	if Passed(EvaluateListing(ListedItem{MediaCondition: GradeMint, SleeveCondition: GradeGeneric}, item)) {
+		t.Error("Expected generic sleeve to fail a minimum sleeve condition")
+	}
+
+	item.Rules = Rules{ShipsFrom: []string{"EU"}, NotShipsFrom: []string{"FR"}}
+
+	if !Passed(EvaluateListing(ListedItem{Country: "DE"}, item)) {
+		t.Error("Expected listing shipping from an allowed region to pass")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Country: "FR"}, item)) {
+		t.Error("Expected listing shipping from a denied country to fail")
+	}
+
+	if Passed(EvaluateListing(ListedItem{Location: "Atlantis"}, item)) {
+		t.Error("Expected listing shipping from an unknown country to fail an allow list")
+	}
+
+	item.Rules = Rules{NotShipsFrom: []string{"US"}}
+
+	if !Passed(EvaluateListing(ListedItem{Location: "Atlantis"}, item)) {
+		t.Error("Expected listing shipping from an unknown country to pass a deny list")
+	}
+}
+
+func TestListingsRule(t *testing.T) {
+	listingRules := Rules{MinMedia: GradeVeryGood}
+
+	cases := []struct {
+		Item     MarketItem
+		Listings int
+		Scraped  bool
+		Passed   bool
+	}{
+		{MarketItem{Listings: []ListedItem{{ID: "1"}}, Rules: listingRules}, 3, true, true},
+		{MarketItem{Rules: listingRules}, 3, true, false},
+		{MarketItem{Rules: listingRules}, 0, false, false},
+		{MarketItem{}, 3, true, false},
+		{MarketItem{}, 0, true, true},
+		{MarketItem{}, 0, false, true},
+	}
+
+	for _, _case := range cases {
+		if result := ListingsRule(_case.Item, _case.Listings, _case.Scraped); result.Passed != _case.Passed {
+			t.Errorf("Expected %t for %d listings (scraped %t) of %v, got %v", _case.Passed, _case.Listings, _case.Scraped, _case.Item, result)
+		}
+	}
+}
+
+// readDecisions reads every decision from a decision log
+func readDecisions(t *testing.T, path string) []Decision {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	decisions := []Decision{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var decision Decision
+		if err := json.Unmarshal(scanner.Bytes(), &decision); err != nil {
+			t.Fatal(err)
+		}
+		decisions = append(decisions, decision)
+	}
+
+	return decisions
+}
+
+func TestDryRunDecisions(t *testing.T) {
+	dir := t.TempDir()
+
+	deduper, err := NewDeduper(filepath.Join(dir, "dedupe.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbox, err := NewOutbox(filepath.Join(dir, "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine, err := NewQuarantine(filepath.Join(dir, "failures.json"), 5, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := NewPriceHistory(filepath.Join(dir, "history.json"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &Notifier{
+		Config:              &Config{Notify: NotifyConfig{DryRun: true}},
+		Channels:            []Channel{&MockChannel{}},
+		previousMarketItems: map[int]MarketItem{},
+		quarantine:          quarantine,
+		history:             history,
+		decisions:           NewDecisionLog(filepath.Join(dir, "decisions.jsonl")),
+		deduper:             deduper,
+		outbox:              outbox,
+	}
+
+	// First seen, then seen again with nothing new for sale
+	for i := 0; i < 2; i++ {
+		result := &itemResult{marketItem: &MarketItem{ID: 1, Name: "Test", NumForSale: 2}}
+		n.evaluateItem(result)
+		n.finishItem(result, &CycleReport{})
+	}
+
+	decisions := readDecisions(t, n.decisions.path)
+	if len(decisions) != 2 {
+		t.Fatalf("Expected 2 decisions, got %v", decisions)
+	}
+
+	if decisions[0].Notify || decisions[0].Rules[0].Rule != "previous_stats" {
+		t.Errorf("Expected first decision to fail previous_stats, got %v", decisions[0])
+	}
+
+	if decisions[1].Notify || decisions[1].Rules[0].Reason != "2 for sale, previously 2" {
+		t.Errorf("Expected second decision to fail num_for_sale, got %v", decisions[1])
+	}
+
+	// The dry run notification decisions don't touch the outbox or deduper
+	for _, decision := range n.dryRunNotify(MarketItem{ID: 1, LowestPrice: 20}) {
+		if !decision.Notify || decision.Recipient != "user@example.com" {
+			t.Errorf("Expected recipient would be notified, got %v", decision)
+		}
+	}
+
+	if pending := outbox.Notifications(NotificationPending); len(pending) != 0 {
+		t.Errorf("Expected no queued notifications in a dry run, got %v", pending)
+	}
+}
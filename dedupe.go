@@ -0,0 +1,187 @@
+package notifier
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How long a sent notification key is remembered before it is forgotten
+var dedupeRetention = 30 * 24 * time.Hour
+
+// Deduper remembers which notifications have been sent to each recipient
+// so the same listing is not notified twice when the number of items for
+// sale bounces up and down. It also enforces a cooldown window per item.
+// State is persisted to disk so restarts don't cause repeat alerts
+type Deduper struct {
+	mu       sync.Mutex
+	path     string
+	cooldown time.Duration
+	state    dedupeState
+}
+
+type dedupeState struct {
+	// Keys maps a recipient to the notification keys they have been sent
+	Keys map[string]map[string]time.Time `json:"keys"`
+
+	// Items maps a recipient to the last time they were notified of each item
+	Items map[string]map[int]time.Time `json:"items"`
+}
+
+// NewDeduper creates a Deduper persisted to path, loading any previously
+// saved state. A cooldown of 0 disables the per item cooldown
+func NewDeduper(path string, cooldown time.Duration) (*Deduper, error) {
+	d := &Deduper{
+		path:     path,
+		cooldown: cooldown,
+		state: dedupeState{
+			Keys:  map[string]map[string]time.Time{},
+			Items: map[string]map[int]time.Time{},
+		},
+	}
+
+	if err := readJSONFile(path, &d.state); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// ListingKey returns the notification key for a marketplace listing
+func ListingKey(listingID string) string {
+	return "listing:" + listingID
+}
+
+// PriceKey returns the notification key for a release at a price. It is
+// used when individual listings are not known
+func PriceKey(releaseID int, price float64) string {
+	return fmt.Sprintf("release:%d:%.2f", releaseID, price)
+}
+
+// NotificationKeys returns the keys identifying a notification for the
+// market item, one per listing if listings were found, otherwise a
+// single key made of the release and its lowest price
+func NotificationKeys(item MarketItem) []string {
+	if len(item.Listings) == 0 {
+		return []string{PriceKey(item.ID, item.LowestPrice)}
+	}
+
+	keys := make([]string, len(item.Listings))
+	for i, listing := range item.Listings {
+		keys[i] = ListingKey(listing.ID)
+	}
+
+	return keys
+}
+
+// filterListingsByKeys returns the listings whose notification key is
+// one of keys
+func filterListingsByKeys(listings []ListedItem, keys []string) []ListedItem {
+	keySet := map[string]bool{}
+	for _, key := range keys {
+		keySet[key] = true
+	}
+
+	filtered := []ListedItem{}
+	for _, listing := range listings {
+		if keySet[ListingKey(listing.ID)] {
+			filtered = append(filtered, listing)
+		}
+	}
+
+	return filtered
+}
+
+// Filter takes a recipient, an item ID and notification keys and returns
+// the keys that have not yet been sent to the recipient.
+// Nothing is returned while the item is in its cooldown window
+func (d *Deduper) Filter(recipient string, itemID int, keys []string, now time.Time) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if last, ok := d.state.Items[recipient][itemID]; ok && now.Sub(last) < d.cooldown {
+		return nil
+	}
+
+	sent := d.state.Keys[recipient]
+
+	filtered := []string{}
+	for _, key := range keys {
+		if _, ok := sent[key]; !ok {
+			filtered = append(filtered, key)
+		}
+	}
+
+	return filtered
+}
+
+// Record marks the keys of an item as sent to the recipient, starting
+// the item's cooldown window, and persists the state to disk
+func (d *Deduper) Record(recipient string, itemID int, keys []string, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state.Keys[recipient] == nil {
+		d.state.Keys[recipient] = map[string]time.Time{}
+	}
+	if d.state.Items[recipient] == nil {
+		d.state.Items[recipient] = map[int]time.Time{}
+	}
+
+	for _, key := range keys {
+		d.state.Keys[recipient][key] = now
+	}
+	d.state.Items[recipient][itemID] = now
+
+	d.prune(now)
+
+	return writeJSONFile(d.path, d.state)
+}
+
+// Forget removes keys recorded for the recipient so they can be notified
+// again, used when a queued notification is never delivered. The item's
+// cooldown is lifted too if it was started by the same notification
+func (d *Deduper) Forget(recipient string, itemID int, keys []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var recorded time.Time
+	for _, key := range keys {
+		if sent, ok := d.state.Keys[recipient][key]; ok {
+			recorded = sent
+			delete(d.state.Keys[recipient], key)
+		}
+	}
+
+	if last, ok := d.state.Items[recipient][itemID]; ok && last.Equal(recorded) {
+		delete(d.state.Items[recipient], itemID)
+	}
+
+	return writeJSONFile(d.path, d.state)
+}
+
+// prune forgets keys older than the retention period and items whose
+// cooldown has passed so the state doesn't grow forever
+func (d *Deduper) prune(now time.Time) {
+	for recipient, keys := range d.state.Keys {
+		for key, sent := range keys {
+			if now.Sub(sent) > dedupeRetention {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(d.state.Keys, recipient)
+		}
+	}
+
+	for recipient, items := range d.state.Items {
+		for id, sent := range items {
+			if now.Sub(sent) >= d.cooldown {
+				delete(items, id)
+			}
+		}
+		if len(items) == 0 {
+			delete(d.state.Items, recipient)
+		}
+	}
+}
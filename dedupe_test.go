@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNotificationKeys(t *testing.T) {
+	item := MarketItem{
+		ID:          1,
+		LowestPrice: 30,
+	}
+
+	keys := NotificationKeys(item)
+	expected := []string{"release:1:30.00"}
+	if !cmp.Equal(keys, expected) {
+		t.Errorf("Expected keys %v, got %v", expected, keys)
+	}
+
+	item.Listings = []ListedItem{
+		ListedItem{ID: "100"},
+		ListedItem{ID: "101"},
+	}
+
+	keys = NotificationKeys(item)
+	expected = []string{"listing:100", "listing:101"}
+	if !cmp.Equal(keys, expected) {
+		t.Errorf("Expected keys %v, got %v", expected, keys)
+	}
+}
+
+func TestDeduper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.json")
+	recipient := "user@example.com"
+	now := time.Now()
+
+	deduper, err := NewDeduper(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{ListingKey("100"), ListingKey("101")}
+
+	// Nothing has been sent so all keys should pass
+	if filtered := deduper.Filter(recipient, 1, keys, now); !cmp.Equal(filtered, keys) {
+		t.Errorf("Expected keys %v, got %v", keys, filtered)
+	}
+
+	if err := deduper.Record(recipient, 1, keys[:1], now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Item is in cooldown so nothing should pass
+	if filtered := deduper.Filter(recipient, 1, keys, now.Add(time.Minute)); len(filtered) != 0 {
+		t.Errorf("Expected no keys during cooldown, got %v", filtered)
+	}
+
+	// Other recipients are unaffected
+	if filtered := deduper.Filter("other@example.com", 1, keys, now); !cmp.Equal(filtered, keys) {
+		t.Errorf("Expected keys %v for other recipient, got %v", keys, filtered)
+	}
+
+	// Reload from disk to check state survives a restart
+	deduper, err = NewDeduper(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the cooldown only the unsent key should pass
+	filtered := deduper.Filter(recipient, 1, keys, now.Add(2*time.Hour))
+	if !cmp.Equal(filtered, keys[1:]) {
+		t.Errorf("Expected keys %v after cooldown, got %v", keys[1:], filtered)
+	}
+}
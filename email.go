@@ -1,46 +1,85 @@
 package notifier
 
 import (
-	"bytes"
-	"fmt"
-	"html/template"
-	"net/smtp"
-	"os"
-
-	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
 )
 
-type NotifyTemplate struct {
-	Name string
-	URL  string
+// EmailChannel delivers notifications by email through an SMTP transport
+type EmailChannel struct {
+	Transport       *SMTPTransport
+	Templates       *Templates
+	ListUnsubscribe string
+	// ChannelName overrides the name notifications are routed by, so
+	// several email channels can share an outbox (defaults to "email")
+	ChannelName string
 }
 
-func SendEmail(msg []byte) error {
-	addr := fmt.Sprintf("%s:%s", os.Getenv("SMTP_ADDRESS"), os.Getenv("SMTP_TLS_PORT"))
-	auth := smtp.PlainAuth("", os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_ADDRESS"))
-	from := os.Getenv("SMTP_USERNAME")
-	recipient := os.Getenv("USER_EMAIL")
+func (c EmailChannel) Name() string {
+	if c.ChannelName != "" {
+		return c.ChannelName
+	}
 
-	err := smtp.SendMail(addr, auth, from, []string{recipient}, msg)
+	return "email"
+}
+
+func (c EmailChannel) Recipient() string {
+	return strings.Join(c.Transport.Recipients(), ",")
+}
+
+func (c EmailChannel) Send(n Notification) error {
+	email, err := c.Message(n)
 	if err != nil {
 		return err
 	}
 
-	log.Infof("Successfully notified %s", recipient)
+	msg, err := email.Bytes()
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return c.Transport.Send(msg)
 }
 
-func ParseTemplate(filename string, data interface{}) (string, error) {
-	t, err := template.ParseFiles(filename)
+// Message creates the email for a notification from the email templates
+// of its event. Emails about the same release share a thread, emails
+// not about a release aren't threaded.
+// Bcc recipients are left out of the headers, they are only added to
+// the envelope by the transport
+func (c EmailChannel) Message(n Notification) (EmailMessage, error) {
+	data := NewTemplateData(n)
+
+	subject, err := c.Templates.Render("email", data.Event, FormatSubject, data)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+
+	html, err := c.Templates.Render("email", data.Event, FormatHTML, data)
 	if err != nil {
-		return "", err
+		return EmailMessage{}, err
 	}
 
-	buf := new(bytes.Buffer)
-	if err = t.Execute(buf, data); err != nil {
-		return "", err
+	text, err := c.Templates.Render("email", data.Event, FormatText, data)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+
+	domain := MessageDomain(c.Transport.From)
+
+	references := []string{}
+	if n.Item.ID != 0 {
+		references = append(references, ReleaseThreadID(n.Item.ID, domain))
 	}
 
-	return buf.String(), nil
+	return EmailMessage{
+		From:            c.Transport.From,
+		To:              c.Transport.To,
+		Subject:         strings.TrimSpace(subject),
+		Date:            time.Now(),
+		MessageID:       NotificationMessageID(n, domain),
+		References:      references,
+		ListUnsubscribe: c.ListUnsubscribe,
+		Text:            text,
+		HTML:            html,
+	}, nil
 }
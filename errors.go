@@ -0,0 +1,116 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrorKind classifies why a request to discogs failed
+type ErrorKind string
+
+const (
+	// ErrorNotFound is a release or list that doesn't exist (404)
+	ErrorNotFound ErrorKind = "not_found"
+	// ErrorUnauthorized is a missing or invalid token (401/403)
+	ErrorUnauthorized ErrorKind = "unauthorized"
+	// ErrorRateLimited is a request still rate limited after retrying (429)
+	ErrorRateLimited ErrorKind = "rate_limited"
+	// ErrorBlocked is a release blocked from sale on the marketplace
+	ErrorBlocked ErrorKind = "blocked"
+	// ErrorDecode is a response that couldn't be decoded
+	ErrorDecode ErrorKind = "decode"
+	// ErrorStatus is any other unsuccessful response
+	ErrorStatus ErrorKind = "status"
+	// ErrorOther is an error which didn't come from a discogs response
+	// e.g. a network failure or invalid rules
+	ErrorOther ErrorKind = "other"
+)
+
+// APIError is a failed request to discogs
+type APIError struct {
+	Kind       ErrorKind
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	message := string(e.Kind)
+	if e.StatusCode != 0 {
+		message = fmt.Sprintf("%s (%d %s)", message, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.URL, message, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s", e.URL, message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKindOf returns the kind of an error, ErrorOther if it isn't an
+// APIError
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+
+	return ErrorOther
+}
+
+// IsPermanent returns whether an error will keep failing if retried, as
+// opposed to failures that may be temporary
+func IsPermanent(err error) bool {
+	switch ErrorKindOf(err) {
+	case ErrorNotFound, ErrorBlocked:
+		return true
+	}
+
+	return false
+}
+
+// IsTransient returns whether an error is a problem with discogs or the
+// network rather than the item it was fetching, such as rate limiting,
+// server errors, a rejected token or a timeout
+func IsTransient(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Kind {
+		case ErrorRateLimited, ErrorUnauthorized:
+			return true
+		case ErrorStatus:
+			return apiErr.StatusCode == 0 || apiErr.StatusCode >= 500
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// checkResponse returns an APIError for unsuccessful responses
+func checkResponse(resp *http.Response, url string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+
+	err := &APIError{Kind: ErrorStatus, URL: url, StatusCode: resp.StatusCode}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err.Kind = ErrorNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err.Kind = ErrorUnauthorized
+	case http.StatusTooManyRequests:
+		err.Kind = ErrorRateLimited
+	}
+
+	return err
+}
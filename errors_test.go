@@ -0,0 +1,98 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckResponse(t *testing.T) {
+	cases := map[int]ErrorKind{
+		http.StatusNotFound:            ErrorNotFound,
+		http.StatusUnauthorized:        ErrorUnauthorized,
+		http.StatusForbidden:           ErrorUnauthorized,
+		http.StatusTooManyRequests:     ErrorRateLimited,
+		http.StatusInternalServerError: ErrorStatus,
+	}
+
+	for status, expected := range cases {
+		err := checkResponse(&http.Response{StatusCode: status}, "https://api.discogs.com/releases/1")
+		if kind := ErrorKindOf(err); kind != expected {
+			t.Errorf("Expected kind %s for status %d, got %s", expected, status, kind)
+		}
+	}
+
+	if err := checkResponse(&http.Response{StatusCode: http.StatusOK}, ""); err != nil {
+		t.Errorf("Expected no error for status 200, got %v", err)
+	}
+
+	if kind := ErrorKindOf(errors.New("network down")); kind != ErrorOther {
+		t.Errorf("Expected kind other for non api error, got %s", kind)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing/1", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/html/1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>Maintenance</html>")
+	})
+	mux.HandleFunc("/limited/1", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	mux.Handle("/blocked/1", MockJsonHandler(t, MarketResponse{Blocked: true}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	retries := apiMaxRetries
+	apiMaxRetries = 0
+	defer func() { apiMaxRetries = retries }()
+
+	cases := map[string]ErrorKind{
+		"/missing/": ErrorNotFound,
+		"/html/":    ErrorDecode,
+		"/limited/": ErrorRateLimited,
+		"/blocked/": ErrorBlocked,
+	}
+
+	for prefix, expected := range cases {
+		_, err := testClient().GetMarketItem(ListItem{ID: 1}, ts.URL+prefix)
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Kind != expected {
+			t.Errorf("Expected %s error for %s, got %v", expected, prefix, err)
+		}
+	}
+
+	if !IsPermanent(&APIError{Kind: ErrorBlocked}) || IsPermanent(&APIError{Kind: ErrorRateLimited}) {
+		t.Error("Expected only blocked and not found errors to be permanent")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := map[error]bool{
+		&APIError{Kind: ErrorRateLimited}:                                    true,
+		&APIError{Kind: ErrorUnauthorized, StatusCode: http.StatusForbidden}: true,
+		&APIError{Kind: ErrorStatus, StatusCode: http.StatusBadGateway}:      true,
+		&APIError{Kind: ErrorStatus, StatusCode: http.StatusBadRequest}:      false,
+		&APIError{Kind: ErrorNotFound, StatusCode: http.StatusNotFound}:      false,
+		&APIError{Kind: ErrorBlocked}:                                        false,
+		&APIError{Kind: ErrorDecode}:                                         false,
+		&net.OpError{Op: "dial", Err: errors.New("connection refused")}:      true,
+		fmt.Errorf("fetching stats: %w", context.DeadlineExceeded):           true,
+		errors.New("invalid rules"):                                          false,
+	}
+
+	for err, expected := range cases {
+		if transient := IsTransient(err); transient != expected {
+			t.Errorf("Expected transient %t for %v, got %t", expected, err, transient)
+		}
+	}
+}
@@ -1,12 +1,13 @@
 module github.com/king-smith/discogs-notifier
 
-go 1.14
+go 1.16
 
 require (
 	github.com/PuerkitoBio/goquery v1.6.1
-	github.com/google/go-cmp v0.5.2
+	github.com/google/go-cmp v0.5.4
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.3.0
+	github.com/prometheus/client_golang v1.10.0
 	github.com/robfig/cron/v3 v3.0.0
 	github.com/shopspring/decimal v1.2.0
 	github.com/sirupsen/logrus v1.7.0
@@ -14,4 +15,5 @@ require (
 	go.mongodb.org/mongo-driver v1.4.5
 	go.uber.org/ratelimit v0.1.1-0.20210125012240-296e9dcf0255
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+	gopkg.in/yaml.v2 v2.4.0
 )
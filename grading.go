@@ -0,0 +1,169 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Grading is the condition of a listing's media or sleeve on the Goldmine
+// scale used by discogs. Gradings are ordered so better grades compare
+// greater, below them are the sleeve only states which aren't grades
+type Grading int
+
+const (
+	// GradeNotGraded is a condition the seller didn't grade (or unknown)
+	GradeNotGraded Grading = iota
+	// GradeNoCover is a sleeve that isn't included
+	GradeNoCover
+	// GradeGeneric is a plain company or replacement sleeve
+	GradeGeneric
+	GradePoor
+	GradeFair
+	GradeGood
+	GradeGoodPlus
+	GradeVeryGood
+	GradeVeryGoodPlus
+	GradeNearMint
+	GradeMint
+)
+
+// gradingNames are the names and abbreviations of each grading, in the
+// order they are written on discogs e.g. 'Near Mint (NM or M-)'
+var gradingNames = []struct {
+	Grading       Grading
+	Name          string
+	Abbreviations []string
+}{
+	{GradeNotGraded, "Not Graded", nil},
+	{GradeNoCover, "No Cover", nil},
+	{GradeGeneric, "Generic", nil},
+	{GradePoor, "Poor", []string{"P"}},
+	{GradeFair, "Fair", []string{"F"}},
+	{GradeGood, "Good", []string{"G"}},
+	{GradeGoodPlus, "Good Plus", []string{"G+"}},
+	{GradeVeryGood, "Very Good", []string{"VG"}},
+	{GradeVeryGoodPlus, "Very Good Plus", []string{"VG+"}},
+	{GradeNearMint, "Near Mint", []string{"NM", "M-"}},
+	{GradeMint, "Mint", []string{"M"}},
+}
+
+// ParseGrading parses a grading from its full name or abbreviation, as
+// written on discogs or in rules e.g. 'Very Good Plus (VG+)', 'VG+' or
+// 'near mint'. Matching is exact (ignoring case) so 'Very Good Plus' is
+// never mistaken for 'Very Good' or 'Good'
+func ParseGrading(text string) (Grading, error) {
+	text = strings.Join(strings.Fields(text), " ")
+
+	// Discogs writes the abbreviations after the name in brackets
+	name, abbreviations := text, ""
+	if i := strings.Index(text, "("); i >= 0 {
+		name = strings.TrimSpace(text[:i])
+		abbreviations = strings.Trim(text[i:], "() ")
+	}
+
+	for _, g := range gradingNames {
+		if strings.EqualFold(name, g.Name) {
+			return g.Grading, nil
+		}
+	}
+
+	candidates := []string{name}
+	if abbreviations != "" {
+		candidates = append(candidates, strings.Fields(abbreviations)[0])
+	}
+
+	for _, candidate := range candidates {
+		for _, g := range gradingNames {
+			for _, abbreviation := range g.Abbreviations {
+				if strings.EqualFold(candidate, abbreviation) {
+					return g.Grading, nil
+				}
+			}
+		}
+	}
+
+	return GradeNotGraded, fmt.Errorf("unknown grading '%s'", text)
+}
+
+// IsGrade returns whether the grading is a grade rather than a sleeve
+// only state (not graded, no cover or generic)
+func (g Grading) IsGrade() bool {
+	return g >= GradePoor && g <= GradeMint
+}
+
+// AtLeast returns whether the grading is a grade at least as good as min.
+// Sleeve only states never satisfy a minimum grade
+func (g Grading) AtLeast(min Grading) bool {
+	return g.IsGrade() && g >= min
+}
+
+// String returns the full name of the grading e.g. 'Very Good Plus'
+func (g Grading) String() string {
+	for _, name := range gradingNames {
+		if name.Grading == g {
+			return name.Name
+		}
+	}
+
+	return fmt.Sprintf("Grading(%d)", int(g))
+}
+
+// Abbreviation returns the abbreviation of a grade e.g. 'VG+', or the name
+// of sleeve only states
+func (g Grading) Abbreviation() string {
+	for _, name := range gradingNames {
+		if name.Grading == g && len(name.Abbreviations) > 0 {
+			return name.Abbreviations[0]
+		}
+	}
+
+	return g.String()
+}
+
+// legacyGradings are the numbers conditions were stored as before
+// Grading, so existing state can still be read
+var legacyGradings = map[int]Grading{
+	0: GradeNotGraded,
+	1: GradeGeneric,
+	2: GradePoor,
+	3: GradeFair,
+	4: GradeGood,
+	5: GradeGoodPlus,
+	6: GradeVeryGood,
+	7: GradeVeryGoodPlus,
+	8: GradeNearMint,
+	9: GradeMint,
+}
+
+// MarshalJSON encodes the grading as its abbreviation
+func (g Grading) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.Abbreviation())
+}
+
+// UnmarshalJSON decodes a grading from its name or abbreviation, or from
+// the numbers conditions were previously stored as
+func (g *Grading) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		legacy, ok := legacyGradings[number]
+		if !ok {
+			return fmt.Errorf("unknown grading %d", number)
+		}
+		*g = legacy
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	grading, err := ParseGrading(text)
+	if err != nil {
+		return err
+	}
+	*g = grading
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package notifier
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseGrading(t *testing.T) {
+	cases := []struct {
+		Text     string
+		Expected Grading
+		Valid    bool
+	}{
+		{"Mint (M)", GradeMint, true},
+		{"Near Mint (NM or M-)", GradeNearMint, true},
+		{"Very Good Plus (VG+)", GradeVeryGoodPlus, true},
+		{"Very Good (VG)", GradeVeryGood, true},
+		{"Good Plus (G+)", GradeGoodPlus, true},
+		{"Good (G)", GradeGood, true},
+		{"Fair (F)", GradeFair, true},
+		{"Poor (P)", GradePoor, true},
+		{"Generic", GradeGeneric, true},
+		{"No Cover", GradeNoCover, true},
+		{"Not Graded", GradeNotGraded, true},
+		{"  Very Good Plus\n  (VG+)  ", GradeVeryGoodPlus, true},
+		{"vg+", GradeVeryGoodPlus, true},
+		{"M-", GradeNearMint, true},
+		{"near mint", GradeNearMint, true},
+		{"(VG)", GradeVeryGood, true},
+		{"Excellent", GradeNotGraded, false},
+		{"", GradeNotGraded, false},
+	}
+
+	for _, c := range cases {
+		grading, err := ParseGrading(c.Text)
+		if (err == nil) != c.Valid {
+			t.Errorf("Expected '%s' valid %v, got %v", c.Text, c.Valid, err)
+			continue
+		}
+
+		if grading != c.Expected {
+			t.Errorf("Expected '%s' to parse as %s, got %s", c.Text, c.Expected, grading)
+		}
+	}
+}
+
+func TestGradingAtLeast(t *testing.T) {
+	cases := []struct {
+		Grading  Grading
+		Min      Grading
+		Expected bool
+	}{
+		{GradeMint, GradeNearMint, true},
+		{GradeVeryGoodPlus, GradeVeryGoodPlus, true},
+		{GradeVeryGood, GradeVeryGoodPlus, false},
+		{GradeGoodPlus, GradeGood, true},
+		{GradeGeneric, GradePoor, false},
+		{GradeNoCover, GradePoor, false},
+		{GradeNotGraded, GradePoor, false},
+	}
+
+	for _, c := range cases {
+		if got := c.Grading.AtLeast(c.Min); got != c.Expected {
+			t.Errorf("Expected %s at least %s to be %v, got %v", c.Grading, c.Min, c.Expected, got)
+		}
+	}
+}
+
+func TestGradingFormat(t *testing.T) {
+	if s := GradeVeryGoodPlus.String(); s != "Very Good Plus" {
+		t.Errorf("Expected 'Very Good Plus', got '%s'", s)
+	}
+
+	if s := GradeNearMint.Abbreviation(); s != "NM" {
+		t.Errorf("Expected 'NM', got '%s'", s)
+	}
+
+	if s := GradeNoCover.Abbreviation(); s != "No Cover" {
+		t.Errorf("Expected 'No Cover', got '%s'", s)
+	}
+}
+
+func TestGradingJSON(t *testing.T) {
+	data, err := json.Marshal(ListedItem{MediaCondition: GradeVeryGoodPlus, SleeveCondition: GradeGeneric})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listing ListedItem
+	if err := json.Unmarshal(data, &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	if listing.MediaCondition != GradeVeryGoodPlus || listing.SleeveCondition != GradeGeneric {
+		t.Errorf("Expected gradings to round trip, got %s and %s from %s", listing.MediaCondition, listing.SleeveCondition, data)
+	}
+
+	// Conditions used to be stored as numbers
+	if err := json.Unmarshal([]byte(`{"MediaCondition":7,"SleeveCondition":1}`), &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	if listing.MediaCondition != GradeVeryGoodPlus || listing.SleeveCondition != GradeGeneric {
+		t.Errorf("Expected legacy conditions to be read, got %s and %s", listing.MediaCondition, listing.SleeveCondition)
+	}
+}
@@ -0,0 +1,196 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health tracks the cycles of the notifier to report whether it is
+// making progress
+type Health struct {
+	mu          sync.Mutex
+	started     time.Time
+	lastCycle   time.Time
+	lastSuccess time.Time
+	lastError   string
+	alerted     bool
+}
+
+// HealthStatus is the health of the notifier served by the health endpoints
+type HealthStatus struct {
+	Status      string     `json:"status"`
+	Started     time.Time  `json:"started"`
+	LastCycle   *time.Time `json:"last_cycle,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// NewHealth creates a Health for a notifier started at now
+func NewHealth(now time.Time) *Health {
+	return &Health{started: now}
+}
+
+// CycleSucceeded returns whether a cycle made progress. A cycle where
+// every list or every item failed (e.g. discogs is erroring) is not a
+// success
+func CycleSucceeded(report CycleReport, err error) bool {
+	if err != nil {
+		return false
+	}
+
+	if report.Lists > 0 && report.ListFailures == report.Lists {
+		return false
+	}
+
+	return report.Items == 0 || report.ItemFailures < report.Items
+}
+
+// RecordCycle records the outcome of a cycle completed at now and returns
+// whether it succeeded
+func (h *Health) RecordCycle(report CycleReport, err error, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastCycle = now
+
+	if !CycleSucceeded(report, err) {
+		switch {
+		case err != nil:
+			h.lastError = err.Error()
+		case report.Lists > 0 && report.ListFailures == report.Lists:
+			h.lastError = fmt.Sprintf("all %d lists failed", report.Lists)
+		default:
+			h.lastError = fmt.Sprintf("all %d items failed", report.Items)
+		}
+		return false
+	}
+
+	h.lastSuccess = now
+	h.lastError = ""
+	h.alerted = false
+
+	return true
+}
+
+// Stalled returns whether no cycle has succeeded within threshold of now
+// (or of starting if none have). A threshold of 0 never stalls
+func (h *Health) Stalled(threshold time.Duration, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.stalled(threshold, now)
+}
+
+// stalled must be called with the lock held
+func (h *Health) stalled(threshold time.Duration, now time.Time) bool {
+	if threshold <= 0 {
+		return false
+	}
+
+	since := h.started
+	if h.lastSuccess.After(since) {
+		since = h.lastSuccess
+	}
+
+	return now.Sub(since) > threshold
+}
+
+// ShouldAlert returns whether the notifier has stalled and an alert hasn't
+// already been sent for it. Alerts are sent once per stall
+func (h *Health) ShouldAlert(threshold time.Duration, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.alerted || !h.stalled(threshold, now) {
+		return false
+	}
+
+	h.alerted = true
+
+	return true
+}
+
+// Ready returns whether a cycle has succeeded
+func (h *Health) Ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !h.lastSuccess.IsZero()
+}
+
+// Status returns the current health with the given status
+func (h *Health) Status(status string) HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := HealthStatus{
+		Status:    status,
+		Started:   h.started,
+		LastError: h.lastError,
+	}
+
+	if !h.lastCycle.IsZero() {
+		lastCycle := h.lastCycle
+		s.LastCycle = &lastCycle
+	}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess := h.lastSuccess
+		s.LastSuccess = &lastSuccess
+	}
+
+	return s
+}
+
+// writeHealth writes the health status as json
+func writeHealth(w http.ResponseWriter, healthy bool, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
+
+// LivenessHandler serves whether a cycle has succeeded within threshold
+func (h *Health) LivenessHandler(threshold time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.Stalled(threshold, time.Now()) {
+			writeHealth(w, false, h.Status("stalled"))
+			return
+		}
+
+		writeHealth(w, true, h.Status("ok"))
+	}
+}
+
+// ReadinessHandler serves whether a cycle has succeeded since starting
+func (h *Health) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			writeHealth(w, false, h.Status("starting"))
+			return
+		}
+
+		writeHealth(w, true, h.Status("ok"))
+	}
+}
+
+// Heartbeat pings a dead man's switch url (e.g. healthchecks.io) to show
+// a cycle succeeded
+func Heartbeat(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("heartbeat returned %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package notifier
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthStalled(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	health := NewHealth(start)
+
+	if health.Stalled(time.Hour, start.Add(time.Minute)) {
+		t.Error("Expected notifier not to be stalled within threshold of starting")
+	}
+
+	if !health.ShouldAlert(time.Hour, start.Add(2*time.Hour)) {
+		t.Error("Expected alert when no cycle succeeded within threshold")
+	}
+
+	if health.ShouldAlert(time.Hour, start.Add(3*time.Hour)) {
+		t.Error("Expected a single alert per stall")
+	}
+
+	// A cycle where every item fails isn't progress
+	if health.RecordCycle(CycleReport{Items: 2, ItemFailures: 2}, nil, start.Add(3*time.Hour)) {
+		t.Error("Expected cycle with every item failing to be unsuccessful")
+	}
+
+	if status := health.Status(""); status.LastError != "all 2 items failed" || status.LastSuccess != nil {
+		t.Errorf("Expected last error and no last success, got %v", status)
+	}
+
+	// Neither is a cycle where every list fails, leaving no items
+	if health.RecordCycle(CycleReport{Lists: 2, ListFailures: 2}, nil, start.Add(3*time.Hour)) {
+		t.Error("Expected cycle with every list failing to be unsuccessful")
+	}
+
+	if status := health.Status(""); status.LastError != "all 2 lists failed" || status.LastSuccess != nil {
+		t.Errorf("Expected last error and no last success, got %v", status)
+	}
+
+	if !health.RecordCycle(CycleReport{Lists: 2, ListFailures: 1, Items: 2, ItemFailures: 1}, nil, start.Add(4*time.Hour)) {
+		t.Error("Expected cycle with some items succeeding to be successful")
+	}
+
+	if health.Stalled(time.Hour, start.Add(4*time.Hour+time.Minute)) {
+		t.Error("Expected notifier not to be stalled after a successful cycle")
+	}
+
+	if !health.ShouldAlert(time.Hour, start.Add(6*time.Hour)) {
+		t.Error("Expected alerts to be resent after a successful cycle")
+	}
+
+	if health.Stalled(0, start.Add(24*time.Hour)) {
+		t.Error("Expected a threshold of 0 to never stall")
+	}
+}
+
+func TestHealthHandlers(t *testing.T) {
+	health := NewHealth(time.Now().Add(-2 * time.Hour))
+
+	cases := []struct {
+		Handler  http.HandlerFunc
+		Expected int
+	}{
+		{health.ReadinessHandler(), http.StatusServiceUnavailable},
+		{health.LivenessHandler(time.Hour), http.StatusServiceUnavailable},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.Handler(w, httptest.NewRequest("GET", "/", nil))
+
+		if w.Code != c.Expected {
+			t.Errorf("Expected status %d before a successful cycle, got %d", c.Expected, w.Code)
+		}
+	}
+
+	health.RecordCycle(CycleReport{}, errors.New("lists unavailable"), time.Now())
+
+	w := httptest.NewRecorder()
+	health.LivenessHandler(time.Hour)(w, httptest.NewRequest("GET", "/healthz", nil))
+	if !strings.Contains(w.Body.String(), "lists unavailable") {
+		t.Errorf("Expected last error in health status, got %s", w.Body.String())
+	}
+
+	health.RecordCycle(CycleReport{Items: 1}, nil, time.Now())
+
+	for _, handler := range []http.HandlerFunc{health.ReadinessHandler(), health.LivenessHandler(time.Hour)} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/", nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200 after a successful cycle, got %d", w.Code)
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	status := http.StatusOK
+	pings := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pings++
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	if err := Heartbeat(ts.Client(), ts.URL); err != nil || pings != 1 {
+		t.Errorf("Expected successful heartbeat, got %v", err)
+	}
+
+	status = http.StatusNotFound
+	if err := Heartbeat(ts.Client(), ts.URL); err == nil {
+		t.Error("Expected error for heartbeat with non 2xx status")
+	}
+}
+
+func TestAlert(t *testing.T) {
+	outbox, err := NewOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := &Notifier{
+		Config:        &Config{},
+		adminChannels: []Channel{&MockChannel{}},
+		outbox:        outbox,
+	}
+
+	n.Alert("No cycle has completed")
+
+	pending := outbox.Notifications(NotificationPending)
+	if len(pending) != 1 {
+		t.Fatalf("Expected 1 queued alert, got %v", pending)
+	}
+
+	if pending[0].Event != EventAdminAlert || pending[0].Message != "No cycle has completed" {
+		t.Errorf("Expected admin alert with message, got %v", pending[0])
+	}
+
+	templates := NewTemplates("")
+	rendered, err := templates.Render("email", EventAdminAlert, FormatText, NewTemplateData(pending[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(rendered, "No cycle has completed") {
+		t.Errorf("Expected alert message in email, got %s", rendered)
+	}
+}
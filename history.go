@@ -0,0 +1,118 @@
+package notifier
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PricePoint is the lowest price of an item on the day starting at Time
+type PricePoint struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+}
+
+// PriceHistory records the lowest price of items each day over a window,
+// so thresholds can be relative to the market rather than fixed. Keeping
+// one price a day bounds the history however often cycles run
+type PriceHistory struct {
+	mu     sync.Mutex
+	path   string
+	window time.Duration
+	items  map[int][]PricePoint
+}
+
+// NewPriceHistory creates a PriceHistory persisted to path which keeps
+// prices for window
+func NewPriceHistory(path string, window time.Duration) (*PriceHistory, error) {
+	h := &PriceHistory{
+		path:   path,
+		window: window,
+		items:  map[int][]PricePoint{},
+	}
+
+	if err := readJSONFile(path, &h.items); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for id := range h.items {
+		h.prune(id, now)
+	}
+
+	return h, nil
+}
+
+// prune removes the prices of an item older than the window at now
+func (h *PriceHistory) prune(id int, now time.Time) {
+	points := []PricePoint{}
+	for _, point := range h.items[id] {
+		if now.Sub(point.Time) <= h.window {
+			points = append(points, point)
+		}
+	}
+
+	if len(points) == 0 {
+		delete(h.items, id)
+	} else {
+		h.items[id] = points
+	}
+}
+
+// Record adds the lowest price of an item at now, keeping the lowest price
+// of each day. Items with nothing for sale have no lowest price so aren't
+// recorded
+func (h *PriceHistory) Record(id int, price float64, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if price > 0 {
+		day := now.UTC().Truncate(24 * time.Hour)
+		points := h.items[id]
+
+		if last := len(points) - 1; last >= 0 && points[last].Time.Equal(day) {
+			if price < points[last].Price {
+				points[last].Price = price
+			}
+		} else {
+			h.items[id] = append(points, PricePoint{Time: day, Price: price})
+		}
+	}
+
+	h.prune(id, now)
+}
+
+// Median returns the median lowest price of an item over the window at
+// now, false if there's no history
+func (h *PriceHistory) Median(id int, now time.Time) (float64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prices := []float64{}
+	for _, point := range h.items[id] {
+		if now.Sub(point.Time) <= h.window {
+			prices = append(prices, point.Price)
+		}
+	}
+
+	if len(prices) == 0 {
+		return 0, false
+	}
+
+	sort.Float64s(prices)
+
+	middle := len(prices) / 2
+	if len(prices)%2 == 0 {
+		return (prices[middle-1] + prices[middle]) / 2, true
+	}
+
+	return prices[middle], true
+}
+
+// Save writes the history to its file
+func (h *PriceHistory) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return writeJSONFile(h.path, h.items)
+}
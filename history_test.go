@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPriceHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	now := time.Now().UTC().Truncate(24 * time.Hour).Add(12 * time.Hour)
+	day := 24 * time.Hour
+
+	history, err := NewPriceHistory(path, 90*day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := history.Median(1, now); ok {
+		t.Error("Expected no median without history")
+	}
+
+	// The oldest price falls outside the window, nothing for sale isn't a price
+	history.Record(1, 100, now.Add(-100*day))
+	history.Record(1, 30, now.Add(-60*day))
+	history.Record(1, 0, now.Add(-30*day))
+	history.Record(1, 20, now.Add(-7*day))
+
+	// Only the lowest price of a day is kept
+	history.Record(1, 25, now)
+	history.Record(1, 40, now.Add(time.Hour))
+	history.Record(1, 22, now.Add(2*time.Hour))
+
+	if points := len(history.items[1]); points != 3 {
+		t.Errorf("Expected 3 daily prices, got %d", points)
+	}
+
+	if median, ok := history.Median(1, now); !ok || median != 22 {
+		t.Errorf("Expected median 22, got %.2f", median)
+	}
+
+	history.Record(1, 50, now.Add(-day))
+	if median, _ := history.Median(1, now); median != 26 {
+		t.Errorf("Expected median 26 of an even number of prices, got %.2f", median)
+	}
+
+	if err := history.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewPriceHistory(path, 90*day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if median, _ := reloaded.Median(1, now); median != 26 {
+		t.Errorf("Expected reloaded median 26, got %.2f", median)
+	}
+
+	if median, _ := reloaded.Median(1, now.Add(31*day)); median != 22 {
+		t.Errorf("Expected median 22 once older prices leave the window, got %.2f", median)
+	}
+
+	// Prices older than the window are pruned when loaded
+	short, err := NewPriceHistory(path, 3*day)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if points := len(short.items[1]); points != 2 {
+		t.Errorf("Expected 2 prices within the window after loading, got %d", points)
+	}
+}
@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"math"
+)
+
+// LandedCost is the estimated cost of a listing delivered to the user, in
+// cents of the user's currency
+type LandedCost struct {
+	Price    int
+	Shipping int
+	Duty     int
+	VAT      int
+	Fee      int
+}
+
+// Charges returns the import charges, excluding the price and shipping
+func (c LandedCost) Charges() int {
+	return c.Duty + c.VAT + c.Fee
+}
+
+// Total returns the landed cost including shipping and import charges
+func (c LandedCost) Total() int {
+	return c.Price + c.Shipping + c.Charges()
+}
+
+// charge returns the import charges for listings shipping from origin,
+// nil if there are none e.g. the listing is shipped domestically
+func (c ImportConfig) charge(origin string) *ImportCharge {
+	if c.Country == "" || origin == c.Country {
+		return nil
+	}
+
+	for i, charge := range c.Charges {
+		if len(charge.From) > 0 && !InLocations(origin, charge.From) {
+			continue
+		}
+		if len(charge.To) > 0 && !InLocations(c.Country, charge.To) {
+			continue
+		}
+
+		return &c.Charges[i]
+	}
+
+	return nil
+}
+
+// LandedCost estimates the cost of a listing delivered to the import
+// country. Listings from unknown countries are only charged by rows
+// matching any origin
+func (c ImportConfig) LandedCost(listing ListedItem) LandedCost {
+	cost := LandedCost{Price: listing.Price, Shipping: listing.ConvertedShipping}
+
+	charge := c.charge(listing.Country)
+	if charge == nil {
+		return cost
+	}
+
+	value := float64(cost.Price + cost.Shipping)
+
+	if value > charge.DutyThreshold*100 {
+		cost.Duty = int(math.Round(value * charge.Duty / 100))
+	}
+	if value > charge.VATThreshold*100 {
+		cost.VAT = int(math.Round((value + float64(cost.Duty)) * charge.VAT / 100))
+	}
+	if cost.Duty > 0 || cost.VAT > 0 {
+		cost.Fee = int(math.Round(charge.Fee * 100))
+	}
+
+	return cost
+}
+
+// EstimateLandedCosts sets the landed cost of each listing
+func (c ImportConfig) EstimateLandedCosts(listings []ListedItem) {
+	for i := range listings {
+		listings[i].Landed = c.LandedCost(listings[i])
+	}
+}
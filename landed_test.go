@@ -0,0 +1,41 @@
+package notifier
+
+import (
+	"testing"
+)
+
+func TestLandedCost(t *testing.T) {
+	imports := ImportConfig{
+		Country: "GB",
+		Charges: []ImportCharge{
+			{From: []string{"Europe"}, To: []string{"Europe"}, VAT: 20},
+			{To: []string{"UK"}, Duty: 2.5, DutyThreshold: 135, VAT: 20, Fee: 8},
+		},
+	}
+
+	cases := []struct {
+		Listing  ListedItem
+		Expected LandedCost
+	}{
+		// Domestic listings aren't charged
+		{ListedItem{Country: "GB", Price: 2000, ConvertedShipping: 300}, LandedCost{Price: 2000, Shipping: 300}},
+		// The first matching row is used
+		{ListedItem{Country: "DE", Price: 2000, ConvertedShipping: 1000}, LandedCost{Price: 2000, Shipping: 1000, VAT: 600}},
+		// Duty is only charged over its threshold
+		{ListedItem{Country: "US", Price: 5000, ConvertedShipping: 2000}, LandedCost{Price: 5000, Shipping: 2000, VAT: 1400, Fee: 800}},
+		{ListedItem{Country: "JP", Price: 15000, ConvertedShipping: 5000}, LandedCost{Price: 15000, Shipping: 5000, Duty: 500, VAT: 4100, Fee: 800}},
+		// Unknown countries only match rows from anywhere
+		{ListedItem{Price: 1000}, LandedCost{Price: 1000, VAT: 200, Fee: 800}},
+	}
+
+	for _, _case := range cases {
+		if cost := imports.LandedCost(_case.Listing); cost != _case.Expected {
+			t.Errorf("Expected %+v for %+v, got %+v", _case.Expected, _case.Listing, cost)
+		}
+	}
+
+	cost := ImportConfig{}.LandedCost(ListedItem{Country: "US", Price: 2000, ConvertedShipping: 500})
+	if cost.Total() != 2500 || cost.Charges() != 0 {
+		t.Errorf("Expected only price and shipping without an import country, got %+v", cost)
+	}
+}
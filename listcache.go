@@ -0,0 +1,110 @@
+package notifier
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cachedList is the last fetched contents of a list
+type cachedList struct {
+	DateChanged string      `json:"date_changed"`
+	Version     ListVersion `json:"version"`
+	Items       []ListItem  `json:"items"`
+	FetchedAt   time.Time   `json:"fetched_at"`
+}
+
+// ListCache caches the items of lists on disk so lists are only fetched
+// when they change, leaving the rate limit for marketplace checks
+type ListCache struct {
+	mu    sync.Mutex
+	path  string
+	lists map[int]cachedList
+	fetch func(url string, version ListVersion) (*ListResponse, ListVersion, error)
+}
+
+// NewListCache creates a ListCache persisted to path which uses fetch to
+// conditionally retrieve lists that may have changed
+func NewListCache(path string, fetch func(url string, version ListVersion) (*ListResponse, ListVersion, error)) (*ListCache, error) {
+	c := &ListCache{
+		path:  path,
+		lists: map[int]cachedList{},
+		fetch: fetch,
+	}
+
+	if err := readJSONFile(path, &c.lists); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Items returns the items of a list. Cached items are used without a
+// request if the list's date_changed hasn't moved, otherwise the list is
+// requested conditionally on the cached version
+func (c *ListCache) Items(list UserList) ([]ListItem, error) {
+	c.mu.Lock()
+	cached, ok := c.lists[list.ID]
+	c.mu.Unlock()
+
+	if ok && list.DateChanged != "" && cached.DateChanged == list.DateChanged {
+		log.Debugf("List '%s' unchanged since %s", list.Name, list.DateChanged)
+		listCacheResults.WithLabelValues("unchanged").Inc()
+		return cached.Items, nil
+	}
+
+	version := ListVersion{}
+	if ok {
+		version = cached.Version
+	}
+
+	data, version, err := c.fetch(list.ResourceURL, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		log.Debugf("List '%s' not modified", list.Name)
+		listCacheResults.WithLabelValues("not_modified").Inc()
+	} else {
+		listCacheResults.WithLabelValues("fetched").Inc()
+		cached.Items = data.Items
+	}
+
+	cached.DateChanged = list.DateChanged
+	cached.Version = version
+	cached.FetchedAt = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lists[list.ID] = cached
+
+	return cached.Items, writeJSONFile(c.path, c.lists)
+}
+
+// Prune removes lists which are no longer watched from the cache
+func (c *ListCache) Prune(lists []UserList) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	watched := map[int]bool{}
+	for _, list := range lists {
+		watched[list.ID] = true
+	}
+
+	pruned := false
+	for id := range c.lists {
+		if !watched[id] {
+			delete(c.lists, id)
+			pruned = true
+		}
+	}
+
+	if !pruned {
+		return nil
+	}
+
+	return writeJSONFile(c.path, c.lists)
+}
@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	rate "go.uber.org/ratelimit"
+)
+
+func TestListCache(t *testing.T) {
+	requests, fetches := 0, 0
+	etag := `"v1"`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		fetches++
+		w.Header().Set("ETag", etag)
+		MockJsonHandler(t, ListResponse{Items: []ListItem{ListItem{ID: 10}}}).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := testClient()
+	client.limiter = rate.NewUnlimited()
+
+	path := filepath.Join(t.TempDir(), "lists.json")
+	cache, err := NewListCache(path, client.GetListIfModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := UserList{ID: 1, ResourceURL: ts.URL, DateChanged: "2021-01-01T00:00:00-08:00"}
+
+	items, err := cache.Items(list)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %v %v", items, err)
+	}
+
+	// Unchanged lists aren't requested, even after reloading the cache
+	cache, err = NewListCache(path, client.GetListIfModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if items, err := cache.Items(list); err != nil || len(items) != 1 || requests != 1 {
+		t.Errorf("Expected cached items without a request, got %v %v after %d requests", items, err, requests)
+	}
+
+	// Changed lists are requested conditionally
+	list.DateChanged = "2021-01-02T00:00:00-08:00"
+
+	if items, err := cache.Items(list); err != nil || len(items) != 1 || requests != 2 || fetches != 1 {
+		t.Errorf("Expected not modified list to use cached items, got %v %v after %d requests", items, err, requests)
+	}
+
+	etag = `"v2"`
+	list.DateChanged = "2021-01-03T00:00:00-08:00"
+
+	if _, err := cache.Items(list); err != nil || fetches != 2 {
+		t.Errorf("Expected modified list to be fetched, got %v after %d fetches", err, fetches)
+	}
+
+	// Lists no longer watched are dropped
+	if err := cache.Prune(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.lists) != 0 {
+		t.Errorf("Expected pruned cache to be empty, got %v", cache.lists)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	notifier "github.com/king-smith/discogs-notifier"
+	log "github.com/sirupsen/logrus"
+)
+
+// command is a subcommand of the notifier
+type command struct {
+	Name        string
+	Args        string
+	Description string
+	// NArgs is the number of arguments the command takes
+	NArgs int
+	// Validate is whether the command needs a valid config to run
+	Validate bool
+	Run      func(config *notifier.Config, args []string) int
+}
+
+var commands = []command{
+	{
+		Name:        "run",
+		Description: "Watch the marketplace and notify of new listings until stopped (default)",
+		Validate:    true,
+		Run:         runCommand,
+	},
+	{
+		Name:        "once",
+		Description: "Check every watched item once, deliver queued notifications and exit (for schedulers)",
+		Validate:    true,
+		Run:         onceCommand,
+	},
+	{
+		Name:        "check",
+		Args:        "<release-id>",
+		Description: "Show a release's market stats and listings and what would be notified",
+		NArgs:       1,
+		Validate:    true,
+		Run:         checkCommand,
+	},
+	{
+		Name:        "lists",
+		Description: "Show watched lists and their items with parsed rules",
+		Validate:    true,
+		Run:         listsCommand,
+	},
+	{
+		Name:        "scrape",
+		Args:        "<release-id>",
+		Description: "Show the scraped marketplace listings of a release",
+		NArgs:       1,
+		Run:         scrapeCommand,
+	},
+	{
+		Name:        "test-notify",
+		Description: "Send a sample notification through each configured channel",
+		Validate:    true,
+		Run:         testNotifyCommand,
+	},
+	{
+		Name:        "config",
+		Args:        "check",
+		Description: "Show the effective configuration (secrets redacted) and validate it",
+		NArgs:       1,
+		Run:         configCommand,
+	},
+}
+
+// findCommand returns the command with the given name
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return command{}, false
+}
+
+// usage prints the available commands
+func usage(w io.Writer, flags func()) {
+	fmt.Fprintf(w, "Usage: %s [flags] <command> [args]\n\nCommands:\n", os.Args[0])
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.Name, c.Args, c.Description)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nFlags:")
+	flags()
+}
+
+// parseReleaseID parses a release ID argument
+func parseReleaseID(arg string) (int, bool) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid release ID '%s'\n", arg)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// printListings writes a table of scraped listings
+func printListings(w io.Writer, listings []notifier.ListedItem) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPRICE\tLANDED\tMEDIA\tSLEEVE\tSELLER\tSHIPS FROM\tAVAILABLE\tOFFERS")
+
+	for _, listing := range listings {
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			listing.ID,
+			float64(listing.Price)/100,
+			float64(listing.Landed.Total())/100,
+			listing.MediaCondition.Abbreviation(),
+			listing.SleeveCondition.Abbreviation(),
+			listing.Seller,
+			listing.Location,
+			yesNo(!listing.Unavailable),
+			yesNo(listing.AcceptsOffers),
+		)
+	}
+
+	tw.Flush()
+}
+
+// printRuleResults writes whether each rule passed and why
+func printRuleResults(results []notifier.RuleResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, result := range results {
+		status := "pass"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", status, result.Rule, result.Reason)
+	}
+	tw.Flush()
+}
+
+// yesNo formats a bool for tables
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// printParseProblems writes the problems found parsing a sell page
+func printParseProblems(problems []string) {
+	if len(problems) == 0 {
+		return
+	}
+
+	fmt.Printf("\nParse problems (selector profile may need updating):\n")
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+}
+
+func runCommand(config *notifier.Config, args []string) int {
+	log.Info("Starting notifier")
+
+	if err := notifier.RunNotifier(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Notifier failed: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Exit codes of the once command
+const (
+	exitOK      = 0
+	exitFailed  = 1
+	exitPartial = 2
+)
+
+func onceCommand(config *notifier.Config, args []string) int {
+	n, err := notifier.NewNotifier(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+
+	// Schedulers send SIGTERM before killing a job, stop the cycle early so
+	// state is saved and queued notifications are flushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := n.Once(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "Cycle stopped before finishing: %v\n", err)
+			return exitPartial
+		}
+
+		fmt.Fprintf(os.Stderr, "Notifier failed: %v\n", err)
+		return exitFailed
+	}
+
+	if report.Failures() > 0 {
+		return exitPartial
+	}
+
+	return exitOK
+}
+
+func checkCommand(config *notifier.Config, args []string) int {
+	id, ok := parseReleaseID(args[0])
+	if !ok {
+		return 1
+	}
+
+	n, err := notifier.NewNotifier(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	result, err := n.Check(id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	item := result.Item
+	if item.Release != nil {
+		fmt.Printf("%s - %s (%d)\n", item.Release.ArtistNames(), item.Release.Title, item.ID)
+	} else {
+		fmt.Printf("%s (%d)\n", item.Name, item.ID)
+	}
+
+	if result.List != nil {
+		fmt.Printf("Watched in:  %s\n", result.List.Name)
+	} else {
+		fmt.Println("Watched in:  not in a watched list")
+	}
+	fmt.Printf("Rules:       %s\n", item.Rules)
+	fmt.Printf("For sale:    %d\n", item.NumForSale)
+	fmt.Printf("Lowest:      %.2f %s\n", item.LowestPrice, item.Currency)
+	if item.MedianLowestPrice > 0 {
+		fmt.Printf("Median:      %.2f %s\n", item.MedianLowestPrice, item.Currency)
+	}
+
+	fmt.Printf("\nListings (%d):\n", len(result.Listings))
+	printListings(os.Stdout, result.Listings)
+	printParseProblems(result.ParseProblems)
+
+	fmt.Printf("\nMatching rules (%d):\n", len(result.Matches))
+	printListings(os.Stdout, result.Matches)
+
+	fmt.Println("\nRules:")
+	printRuleResults(append(result.ItemRules, result.ListingsRule))
+
+	recipients := []string{}
+	for recipient := range result.Unnotified {
+		recipients = append(recipients, recipient)
+	}
+	sort.Strings(recipients)
+
+	fmt.Println()
+	for _, recipient := range recipients {
+		keys := result.Unnotified[recipient]
+		switch {
+		case !result.Notify:
+			fmt.Printf("Would not notify %s: rules not satisfied\n", recipient)
+		case len(keys) == 0:
+			fmt.Printf("Would not notify %s: already notified or in cooldown\n", recipient)
+		case len(result.Matches) == 0:
+			fmt.Printf("Would notify %s of the lowest price\n", recipient)
+		default:
+			fmt.Printf("Would notify %s of %d new listing(s)\n", recipient, len(keys))
+		}
+	}
+
+	return 0
+}
+
+func listsCommand(config *notifier.Config, args []string) int {
+	n, err := notifier.NewNotifier(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	lists, err := n.WatchedLists()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, list := range lists {
+		if list.Err != nil {
+			fmt.Printf("%s: unable to fetch items: %v\n", list.List.Name, list.Err)
+			continue
+		}
+
+		fmt.Printf("%s (%d items) rules: %s\n", list.List.Name, len(list.Items), list.Rules)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, item := range list.Items {
+			rules, err := notifier.ParseRules(item.Comment)
+			if err != nil {
+				fmt.Fprintf(tw, "  %d\t%s\tinvalid rules: %v\n", item.ID, item.Title, err)
+				continue
+			}
+
+			fmt.Fprintf(tw, "  %d\t%s\t%s\n", item.ID, item.Title, list.Rules.Merge(rules))
+		}
+		tw.Flush()
+	}
+
+	return 0
+}
+
+func scrapeCommand(config *notifier.Config, args []string) int {
+	if _, ok := parseReleaseID(args[0]); !ok {
+		return 1
+	}
+
+	profile, err := notifier.LoadSelectorProfile(config.Scraper.Selectors)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	listings, report, err := notifier.ScrapeListedItems(context.Background(), args[0], profile, notifier.ScrapeOptions{MaxPages: config.Scraper.MaxPages})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	config.Imports.EstimateLandedCosts(listings)
+	printListings(os.Stdout, listings)
+
+	// Without the release's stats an empty page can't be told apart from
+	// one that doesn't parse
+	problems := report.Problems(0)
+	if report.Listings == 0 {
+		problems = append(problems, "no listings found")
+	}
+	printParseProblems(problems)
+
+	return 0
+}
+
+func testNotifyCommand(config *notifier.Config, args []string) int {
+	n, err := notifier.NewNotifier(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	status := 0
+	for _, channel := range n.Channels {
+		if err := channel.Send(notifier.SampleNotification(channel)); err != nil {
+			fmt.Printf("%s (%s): failed: %v\n", channel.Name(), channel.Recipient(), err)
+			status = 1
+			continue
+		}
+
+		fmt.Printf("%s (%s): sent\n", channel.Name(), channel.Recipient())
+	}
+
+	return status
+}
+
+func configCommand(config *notifier.Config, args []string) int {
+	if args[0] != "check" {
+		fmt.Fprintf(os.Stderr, "Unknown config command '%s'\n", args[0])
+		return 2
+	}
+
+	return configCheck(config)
+}
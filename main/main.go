@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
 
 	"github.com/joho/godotenv"
@@ -8,10 +10,73 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// configPath returns the config file to load. Set by the '-config' flag
+// or 'CONFIG_FILE', otherwise 'config.yaml' is used if it exists
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+
+	if _, err := os.Stat("config.yaml"); err == nil {
+		return "config.yaml"
+	}
+
+	return ""
+}
+
+// configCheck prints the effective configuration with secrets redacted
+// and whether it is valid
+func configCheck(config *notifier.Config) int {
+	out, err := config.Redacted().YAML()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Print(out)
+
+	if err := config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+
+	return 0
+}
+
 func main() {
+	configFlag := flag.String("config", "", "path to a yaml config file")
+	dryRunFlag := flag.Bool("dry-run", false, "log notification decisions instead of sending notifications")
+	flag.Usage = func() {
+		usage(flag.CommandLine.Output(), flag.PrintDefaults)
+	}
+	flag.Parse()
 
 	log.SetFormatter(&log.JSONFormatter{})
 
+	// Run the notifier if no command is given
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"run"}
+	}
+
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if len(args)-1 != cmd.NArgs {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n", os.Args[0], cmd.Name, cmd.Args)
+		os.Exit(2)
+	}
+
 	// Check .env exists
 	if _, err := os.Stat(".env"); err == nil {
 		err := godotenv.Load(".env")
@@ -23,13 +88,26 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if os.Getenv("VERBOSE") == "true" {
-		log.SetLevel(log.DebugLevel)
+	config, err := notifier.LoadConfig(configPath(*configFlag))
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	log.Info("Starting notifier")
+	if *dryRunFlag {
+		config.Notify.DryRun = true
+	}
+
+	if cmd.Validate {
+		if err := config.Validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	if err := notifier.RunNotifier(); err != nil {
-		log.Errorf("Notifier failed: %v", err)
+	if config.Verbose {
+		log.SetLevel(log.DebugLevel)
 	}
+
+	log.Debugf("Running command '%s'", cmd.Name)
+
+	os.Exit(cmd.Run(config, args[1:]))
 }
@@ -0,0 +1,193 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailMessage is an email with a plain text and html alternative body
+// which can be serialised into an RFC 5322 / RFC 2045 compliant message
+type EmailMessage struct {
+	From            string
+	To              []string
+	Subject         string
+	Date            time.Time
+	MessageID       string
+	References      []string
+	ListUnsubscribe string
+	Text            string
+	HTML            string
+}
+
+// headerSanitiser strips line breaks from header values so untrusted
+// values such as release titles can't inject extra headers
+var headerSanitiser = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// sanitiseHeader makes a value safe to use in a header
+func sanitiseHeader(value string) string {
+	return strings.TrimSpace(headerSanitiser.Replace(value))
+}
+
+// encodeHeader encodes a header value as RFC 2047 encoded words if it
+// contains non ASCII characters, folding long values across lines
+func encodeHeader(value string) string {
+	encoded := mime.QEncoding.Encode("utf-8", sanitiseHeader(value))
+
+	// Encoded words are separated by spaces, fold between them so lines
+	// stay within the recommended length
+	return strings.ReplaceAll(encoded, "?= =?", "?=\r\n =?")
+}
+
+// formatAddress formats an email address for use in a header
+func formatAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(sanitiseHeader(address))
+	if err != nil {
+		return "", fmt.Errorf("invalid address '%s': %v", address, err)
+	}
+
+	return parsed.String(), nil
+}
+
+// envelopeAddress returns the bare address of an email address for the
+// SMTP envelope, which can't contain a display name
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(sanitiseHeader(address))
+	if err != nil {
+		return "", fmt.Errorf("invalid address '%s': %v", address, err)
+	}
+
+	return parsed.Address, nil
+}
+
+// MessageDomain returns the domain used in message IDs, taken from the
+// from address when possible
+func MessageDomain(from string) string {
+	if parsed, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(parsed.Address, "@"); i >= 0 && i < len(parsed.Address)-1 {
+			return parsed.Address[i+1:]
+		}
+	}
+
+	return "discogs-notifier"
+}
+
+// ReleaseThreadID returns a stable message ID for a release. Every email
+// about the release references it so mail clients thread them together
+func ReleaseThreadID(releaseID int, domain string) string {
+	return fmt.Sprintf("<release-%d@%s>", releaseID, domain)
+}
+
+// NotificationMessageID returns the message ID of a notification. It is
+// derived from the notification ID so retries reuse the same message ID
+func NotificationMessageID(n Notification, domain string) string {
+	if n.Item.ID == 0 {
+		return fmt.Sprintf("<%s@%s>", n.ID, domain)
+	}
+
+	return fmt.Sprintf("<%s.release-%d@%s>", n.ID, n.Item.ID, domain)
+}
+
+// writeQuotedPrintablePart writes a quoted printable encoded part
+func writeQuotedPrintablePart(w *multipart.Writer, contentType, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
+
+// Bytes serialises the message into a multipart/alternative email
+func (m EmailMessage) Bytes() ([]byte, error) {
+	from, err := formatAddress(m.From)
+	if err != nil {
+		return nil, err
+	}
+
+	to := make([]string, len(m.To))
+	for i, address := range m.To {
+		if to[i], err = formatAddress(address); err != nil {
+			return nil, err
+		}
+	}
+
+	// Messages only sent to Bcc recipients still need a To header
+	if len(to) == 0 {
+		to = []string{"undisclosed-recipients:;"}
+	}
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+
+	// Parts are ordered from least to most preferred
+	if err := writeQuotedPrintablePart(w, "text/plain; charset=UTF-8", m.Text); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintablePart(w, "text/html; charset=UTF-8", m.HTML); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	headers := [][2]string{
+		{"From", from},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", encodeHeader(m.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+	}
+
+	if m.MessageID != "" {
+		headers = append(headers, [2]string{"Message-ID", sanitiseHeader(m.MessageID)})
+	}
+
+	if len(m.References) > 0 {
+		references := make([]string, len(m.References))
+		for i, reference := range m.References {
+			references[i] = sanitiseHeader(reference)
+		}
+
+		headers = append(headers,
+			[2]string{"In-Reply-To", references[len(references)-1]},
+			[2]string{"References", strings.Join(references, " ")},
+		)
+	}
+
+	if m.ListUnsubscribe != "" {
+		headers = append(headers, [2]string{"List-Unsubscribe", "<" + sanitiseHeader(m.ListUnsubscribe) + ">"})
+	}
+
+	headers = append(headers,
+		[2]string{"MIME-Version", "1.0"},
+		[2]string{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=\"%s\"", w.Boundary())},
+	)
+
+	msg := new(bytes.Buffer)
+	for _, header := range headers {
+		fmt.Fprintf(msg, "%s: %s\r\n", header[0], header[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,144 @@
+package notifier
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailMessageBytes(t *testing.T) {
+	n := Notification{
+		ID:   "abc123",
+		Item: MarketItem{ID: 42, Name: "Sigur Rós – Ágætis Byrjun"},
+	}
+
+	domain := MessageDomain("Notifier <notifier@example.com>")
+	if domain != "example.com" {
+		t.Errorf("Expected domain example.com, got %s", domain)
+	}
+
+	message := EmailMessage{
+		From:            "Notifier <notifier@example.com>",
+		To:              []string{"user@example.com", "other@example.com"},
+		Subject:         "New " + n.Item.Name + " listed!\r\nBcc: victim@example.com",
+		Date:            time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC),
+		MessageID:       NotificationMessageID(n, domain),
+		References:      []string{ReleaseThreadID(n.Item.ID, domain)},
+		ListUnsubscribe: "mailto:notifier@example.com?subject=unsubscribe",
+		Text:            "Plain text body",
+		HTML:            "<p>HTML body</p>",
+	}
+
+	raw, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Injected header must not be parsed as a header
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("Expected no Bcc header, got %s", bcc)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "New Sigur Rós – Ágætis Byrjun listed! Bcc: victim@example.com"; subject != expected {
+		t.Errorf("Expected subject '%s', got '%s'", expected, subject)
+	}
+
+	to, err := msg.Header.AddressList("To")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(to) != 2 {
+		t.Errorf("Expected 2 recipients, got %v", to)
+	}
+
+	expectedHeaders := map[string]string{
+		"Message-ID":       "<abc123.release-42@example.com>",
+		"In-Reply-To":      "<release-42@example.com>",
+		"References":       "<release-42@example.com>",
+		"List-Unsubscribe": "<mailto:notifier@example.com?subject=unsubscribe>",
+		"MIME-Version":     "1.0",
+		"Date":             "Mon, 01 Feb 2021 10:00:00 +0000",
+	}
+	for header, expected := range expectedHeaders {
+		if actual := msg.Header.Get(header); actual != expected {
+			t.Errorf("Expected %s '%s', got '%s'", header, expected, actual)
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %s", mediaType)
+	}
+
+	expectedParts := []struct {
+		ContentType string
+		Body        string
+	}{
+		{"text/plain; charset=UTF-8", "Plain text body"},
+		{"text/html; charset=UTF-8", "<p>HTML body</p>"},
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for _, expected := range expectedParts {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if contentType := part.Header.Get("Content-Type"); contentType != expected.ContentType {
+			t.Errorf("Expected part content type %s, got %s", expected.ContentType, contentType)
+		}
+
+		// The multipart reader transparently decodes quoted printable
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != expected.Body {
+			t.Errorf("Expected part body '%s', got '%s'", expected.Body, body)
+		}
+	}
+
+	if _, err := reader.NextPart(); err == nil {
+		t.Error("Expected exactly 2 parts")
+	}
+
+	if strings.Contains(strings.ReplaceAll(string(raw), "\r\n", ""), "\n") {
+		t.Error("Expected CRLF line endings throughout")
+	}
+}
+
+func TestEmailMessageInvalidAddress(t *testing.T) {
+	message := EmailMessage{
+		From: "not an address",
+		To:   []string{"user@example.com"},
+	}
+
+	if _, err := message.Bytes(); err == nil {
+		t.Error("Expected error for invalid from address")
+	}
+
+	message.From = "notifier@example.com"
+	message.To = []string{"not an address"}
+
+	if _, err := message.Bytes(); err == nil {
+		t.Error("Expected error for invalid to address")
+	}
+}
@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "discogs_notifier"
+
+var (
+	apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "Discogs API requests by endpoint and response status ('error' if no response).",
+	}, []string{"endpoint", "status"})
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of Discogs API requests by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	apiRateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_rate_limited_total",
+		Help:      "Discogs API responses with status 429 Too Many Requests.",
+	})
+
+	apiRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_retries_total",
+		Help:      "Discogs API requests retried.",
+	})
+
+	rateLimiterWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limiter_wait_seconds",
+		Help:      "Time spent waiting on the rate limiter before a request.",
+		Buckets:   []float64{0.001, 0.01, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	})
+
+	scrapeParseFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "scrape_parse_failures_total",
+		Help:      "Marketplace listings which couldn't be parsed when scraping.",
+	})
+
+	itemFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "item_failures_total",
+		Help:      "Market items which couldn't be fetched by error kind.",
+	}, []string{"kind"})
+
+	quarantinedItems = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "quarantined_items",
+		Help:      "Market items skipped due to persistent failures.",
+	})
+
+	listCacheResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "list_cache_total",
+		Help:      "Watched lists by how their items were retrieved ('unchanged', 'not_modified' or 'fetched').",
+	}, []string{"result"})
+
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pipeline_queue_depth",
+		Help:      "Items waiting in each stage of the cycle pipeline ('lists', 'stats' or 'scrape').",
+	}, []string{"stage"})
+
+	cycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "cycle_duration_seconds",
+		Help:      "Duration of a cycle over every watched item.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	itemsEvaluated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "items_evaluated_total",
+		Help:      "Market items checked against their rules by result ('notify' or 'skip').",
+	}, []string{"result"})
+
+	notificationsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_sent_total",
+		Help:      "Notifications delivered by channel.",
+	}, []string{"channel"})
+
+	notificationsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_failed_total",
+		Help:      "Notification delivery attempts which failed by channel.",
+	}, []string{"channel"})
+)
+
+// apiEndpoint returns the endpoint of a Discogs API url for labelling
+// metrics without including IDs
+func apiEndpoint(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "other"
+	}
+
+	switch path := u.Path; {
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/lists"):
+		return "user_lists"
+	case strings.HasPrefix(path, "/lists/"):
+		return "list"
+	case strings.HasPrefix(path, "/marketplace/stats/"):
+		return "marketplace_stats"
+	case strings.HasPrefix(path, "/marketplace/price_suggestions/"):
+		return "price_suggestions"
+	case strings.HasPrefix(path, "/releases/"):
+		return "release"
+	}
+
+	return "other"
+}
+
+// Handler returns the HTTP handler serving the notifier's metrics and
+// health endpoints
+func (n *Notifier) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", n.health.LivenessHandler(time.Duration(n.Config.Health.StallThreshold)))
+	mux.Handle("/readyz", n.health.ReadinessHandler())
+
+	return mux
+}
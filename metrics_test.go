@@ -0,0 +1,72 @@
+package notifier
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"https://api.discogs.com/users/digger/lists?page=2":       "user_lists",
+		"https://api.discogs.com/lists/123":                       "list",
+		"https://api.discogs.com/marketplace/stats/1?curr_abbr=":  "marketplace_stats",
+		"https://api.discogs.com/marketplace/price_suggestions/1": "price_suggestions",
+		"https://api.discogs.com/releases/1":                      "release",
+		"http://127.0.0.1:1234/":                                  "other",
+	}
+
+	for url, expected := range cases {
+		if endpoint := apiEndpoint(url); endpoint != expected {
+			t.Errorf("Expected endpoint %s for %s, got %s", expected, url, endpoint)
+		}
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	ts := httptest.NewServer(MockJsonHandler(t, struct{}{}))
+	defer ts.Close()
+
+	requests := apiRequests.WithLabelValues("release", "201")
+	before := testutil.ToFloat64(requests)
+
+	resp, err := testClient().AuthenticatedRequest(ts.URL + "/releases/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if count := testutil.ToFloat64(requests) - before; count != 1 {
+		t.Errorf("Expected 1 release request counted, got %f", count)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ts := httptest.NewServer((&Notifier{Config: &Config{}, health: NewHealth(time.Now())}).Handler())
+	defer ts.Close()
+
+	apiRequests.WithLabelValues("other", "200").Add(0)
+	notificationsSent.WithLabelValues("mock").Add(0)
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"discogs_notifier_api_requests_total", "discogs_notifier_notifications_sent_total", "discogs_notifier_rate_limiter_wait_seconds"} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("Expected metrics to contain %s", name)
+		}
+	}
+}
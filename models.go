@@ -63,30 +63,55 @@ type MarketResponse struct {
 }
 
 type MarketItem struct {
-	ID           int
-	NumForSale   int
-	MinimumPrice float64
-	LowestPrice  float64
-	Name         string
-	URL          string
-	Currency     string
+	ID                  int
+	NumForSale          int
+	MinimumPrice        float64
+	Rules               Rules
+	LowestPrice         float64
+	PreviousLowestPrice float64
+	Name                string
+	URL                 string
+	Currency            string
+	Release             *Release
+	Listings            []ListedItem
+	// MedianLowestPrice is the median lowest price over the price history,
+	// 0 if there's no history
+	MedianLowestPrice float64
+	// PriceSuggestions are discogs' suggested prices by media condition,
+	// only fetched for items with a rule using them once their listings
+	// are scraped
+	PriceSuggestions map[Grading]LowestPrice
+	// SeenListings are the IDs of the listings last scraped
+	SeenListings []string
 }
 
-func (item MarketItem) CreateEmailMessage() ([]byte, error) {
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	subject := "Subject: New " + item.Name + " listed!" + "\n"
-
-	templateData := NotifyTemplate{
-		Name: item.Name,
-		URL:  item.URL,
-	}
+type Artist struct {
+	Name string `json:"name"`
+	Join string `json:"join"`
+}
 
-	body, err := ParseTemplate("email_template.html", templateData)
-	if err != nil {
-		return nil, err
-	}
+type Label struct {
+	Name          string `json:"name"`
+	CatalogNumber string `json:"catno"`
+}
 
-	msg := []byte(subject + mime + "\n" + body)
+type Format struct {
+	Name         string   `json:"name"`
+	Quantity     string   `json:"qty"`
+	Text         string   `json:"text"`
+	Descriptions []string `json:"descriptions"`
+}
 
-	return msg, nil
+type Release struct {
+	ID      int      `json:"id"`
+	Title   string   `json:"title"`
+	URL     string   `json:"uri"`
+	Artists []Artist `json:"artists"`
+	Year    int      `json:"year"`
+	Labels  []Label  `json:"labels"`
+	Formats []Format `json:"formats"`
+	Genres  []string `json:"genres"`
+	Styles  []string `json:"styles"`
+	Country string   `json:"country"`
+	Thumb   string   `json:"thumb"`
 }
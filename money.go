@@ -0,0 +1,172 @@
+package notifier
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in the minor units of its currency e.g. cents, or
+// yen for currencies without decimals
+type Money struct {
+	Amount int64
+	// Currency is the ISO 4217 code, empty if it couldn't be detected
+	Currency string
+}
+
+// zeroDecimalCurrencies are currencies without minor units
+var zeroDecimalCurrencies = map[string]bool{
+	"CLP": true,
+	"ISK": true,
+	"JPY": true,
+	"KRW": true,
+}
+
+// currencySymbols maps the symbols used in prices to currency codes,
+// longest first so 'A$' is matched before '$'
+var currencySymbols = []struct {
+	Symbol   string
+	Currency string
+}{
+	{"CA$", "CAD"},
+	{"NZ$", "NZD"},
+	{"MX$", "MXN"},
+	{"US$", "USD"},
+	{"A$", "AUD"},
+	{"C$", "CAD"},
+	{"R$", "BRL"},
+	{"$", "USD"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+	{"¥", "JPY"},
+	{"₩", "KRW"},
+	{"zł", "PLN"},
+}
+
+var (
+	currencyCodePattern = regexp.MustCompile(`\b[A-Z]{3}\b`)
+	// Digits with the separators used for thousands and decimals, including
+	// apostrophes (CHF) and spaces (e.g. '1 234,56')
+	amountPattern = regexp.MustCompile(`[0-9](?:[0-9.,'\x{00a0}\x{202f} ]*[0-9])?`)
+)
+
+// CurrencyDecimals returns the number of decimal places of a currency
+func CurrencyDecimals(currency string) int {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return 0
+	}
+
+	return 2
+}
+
+// detectCurrency returns the currency of a price from its code or symbol
+func detectCurrency(text string) string {
+	if code := currencyCodePattern.FindString(text); code != "" {
+		return code
+	}
+
+	for _, s := range currencySymbols {
+		if strings.Contains(text, s.Symbol) {
+			return s.Currency
+		}
+	}
+
+	return ""
+}
+
+// ParseMoney parses a price as written on discogs in any locale e.g.
+// '€1.234,56', '1 234,56 €', '¥12,000', 'about $1,050.00' or 'CHF 1'234.50'.
+//
+// The currency is detected from its code or symbol. The decimal separator
+// is the last '.' or ',' when both are used. A lone separator is for
+// thousands if it is repeated or followed by three digits, otherwise it is
+// the decimal separator. Amounts in zero decimal currencies (e.g. JPY) are
+// rounded to whole units
+func ParseMoney(text string) (Money, error) {
+	money := Money{Currency: detectCurrency(text)}
+
+	number := amountPattern.FindString(text)
+	if number == "" {
+		return money, fmt.Errorf("no amount in price '%s'", text)
+	}
+
+	// Spaces and apostrophes only ever separate thousands
+	number = strings.NewReplacer(" ", "", "'", "", "\u00a0", "", "\u202f", "").Replace(number)
+
+	whole, fraction := number, ""
+	lastDot, lastComma := strings.LastIndex(number, "."), strings.LastIndex(number, ",")
+
+	decimal := -1
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimal = lastDot
+		if lastComma > lastDot {
+			decimal = lastComma
+		}
+	case lastDot >= 0 || lastComma >= 0:
+		separator := "."
+		if lastComma >= 0 {
+			separator = ","
+		}
+		i := strings.LastIndex(number, separator)
+
+		if strings.Count(number, separator) == 1 && len(number)-i-1 != 3 {
+			decimal = i
+		}
+	}
+
+	if decimal >= 0 {
+		whole, fraction = number[:decimal], number[decimal+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+
+	if strings.ContainsAny(fraction, ".,") {
+		return money, fmt.Errorf("invalid amount in price '%s'", text)
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return money, fmt.Errorf("invalid amount in price '%s': %v", text, err)
+	}
+
+	// Scale the fraction to the currency's minor units, rounding any
+	// further digits
+	decimals := CurrencyDecimals(money.Currency)
+	minor := 0.0
+	if fraction != "" {
+		f, err := strconv.ParseFloat("0."+fraction, 64)
+		if err != nil {
+			return money, fmt.Errorf("invalid amount in price '%s': %v", text, err)
+		}
+		minor = f
+	}
+
+	scale := math.Pow10(decimals)
+	money.Amount = units*int64(scale) + int64(math.Round(minor*scale))
+
+	return money, nil
+}
+
+// Float returns the amount in whole units of the currency
+func (m Money) Float() float64 {
+	return float64(m.Amount) / math.Pow10(CurrencyDecimals(m.Currency))
+}
+
+// Cents returns the amount in hundredths of a unit of the currency, as
+// scraped prices are stored
+func (m Money) Cents() int {
+	return int(math.Round(m.Float() * 100))
+}
+
+// String formats the amount with its currency e.g. '12.50 EUR'
+func (m Money) String() string {
+	return FormatMoney(m.Float(), m.Currency)
+}
+
+// FormatMoney formats an amount in whole units with the decimals of its
+// currency e.g. '12.50 EUR' or '1200 JPY'
+func FormatMoney(amount float64, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%.*f %s", CurrencyDecimals(currency), amount, currency))
+}
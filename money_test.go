@@ -0,0 +1,76 @@
+package notifier
+
+import (
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		Text     string
+		Expected Money
+		Valid    bool
+	}{
+		{"$20.00", Money{2000, "USD"}, true},
+		{"$20", Money{2000, "USD"}, true},
+		{"about $1,050.00", Money{105000, "USD"}, true},
+		{"€1.234,56", Money{123456, "EUR"}, true},
+		{"1.234,56 €", Money{123456, "EUR"}, true},
+		{"1 234,56 €", Money{123456, "EUR"}, true},
+		{"1\u00a0234,56\u00a0€", Money{123456, "EUR"}, true},
+		{"1\u202f234,56 €", Money{123456, "EUR"}, true},
+		{"€1.234", Money{123400, "EUR"}, true},
+		{"12,5 €", Money{1250, "EUR"}, true},
+		{"€0,99", Money{99, "EUR"}, true},
+		{"¥12,000", Money{12000, "JPY"}, true},
+		{"about ¥1,234", Money{1234, "JPY"}, true},
+		{"¥1234.6", Money{1235, "JPY"}, true},
+		{"₩45,000", Money{45000, "KRW"}, true},
+		{"CHF 1'234.50", Money{123450, "CHF"}, true},
+		{"A$35.00", Money{3500, "AUD"}, true},
+		{"about A$35.00 total", Money{3500, "AUD"}, true},
+		{"CA$1,299.99", Money{129999, "CAD"}, true},
+		{"+£3.00 shipping", Money{300, "GBP"}, true},
+		{"R$ 45,90", Money{4590, "BRL"}, true},
+		{"45.90 SEK", Money{4590, "SEK"}, true},
+		{"1.234.567,8 DKK", Money{123456780, "DKK"}, true},
+		{"1,234,567", Money{123456700, ""}, true},
+		{"1.5", Money{150, ""}, true},
+		{"10.999", Money{1099900, ""}, true},
+		{"free shipping", Money{}, false},
+		{"", Money{}, false},
+	}
+
+	for _, c := range cases {
+		money, err := ParseMoney(c.Text)
+		if (err == nil) != c.Valid {
+			t.Errorf("Expected '%s' valid %v, got %v", c.Text, c.Valid, err)
+			continue
+		}
+
+		if c.Valid && money != c.Expected {
+			t.Errorf("Expected '%s' to parse as %v, got %v", c.Text, c.Expected, money)
+		}
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	cases := []struct {
+		Money    Money
+		Cents    int
+		Expected string
+	}{
+		{Money{1250, "EUR"}, 1250, "12.50 EUR"},
+		{Money{12000, "JPY"}, 1200000, "12000 JPY"},
+		{Money{99, ""}, 99, "0.99"},
+	}
+
+	for _, c := range cases {
+		if s := c.Money.String(); s != c.Expected {
+			t.Errorf("Expected %v to format as '%s', got '%s'", c.Money, c.Expected, s)
+		}
+
+		if cents := c.Money.Cents(); cents != c.Cents {
+			t.Errorf("Expected %v to be %d cents, got %d", c.Money, c.Cents, cents)
+		}
+	}
+}
@@ -1,10 +1,11 @@
 package notifier
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -20,61 +21,140 @@ var notifyTag = "notify_me"
 // before each Get method. (Should take after successful API call)
 var limiter = rate.New(1)
 
+// Client makes requests to the discogs api
+type Client struct {
+	Token    string
+	Currency string
+	APIURL   string
+	// WebURL is the website sell pages are scraped from
+	WebURL     string
+	HTTPClient *http.Client
+	Selectors  *SelectorProfile
+	// ScrapeMaxPages is the most sell pages scraped for a release
+	ScrapeMaxPages int
+	limiter        rate.Limiter
+}
+
+// NewClient creates a Client from the discogs config. All clients share
+// the same rate limiter
+func NewClient(config DiscogsConfig) *Client {
+	return &Client{
+		Token:          config.Token,
+		Currency:       config.Currency,
+		APIURL:         config.APIURL,
+		WebURL:         DefaultWebURL,
+		HTTPClient:     &http.Client{Timeout: 30 * time.Second},
+		Selectors:      DefaultSelectorProfile(),
+		ScrapeMaxPages: 1,
+		limiter:        limiter,
+	}
+}
+
+// Number of times a rate limited request is retried
+var apiMaxRetries = 3
+
 // AuthenticatedRequest takes a url and makes a request
-// with authorization added to the header
-// Authorization token 'DISCOGS_TOKEN' is set by .env file
-func AuthenticatedRequest(url string) (*http.Response, error) {
-	client := &http.Client{}
+// with authorization added to the header.
+// Rate limited requests are retried, the response of any other
+// unsuccessful request is returned to be checked by the caller
+func (c *Client) AuthenticatedRequest(url string) (*http.Response, error) {
+	return c.authenticatedRequest(url, nil, 0)
+}
+
+// authenticatedRequest makes the request with any extra headers given
+// e.g. for conditional requests
+func (c *Client) authenticatedRequest(url string, header http.Header, retries int) (*http.Response, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	discogsToken := os.Getenv("DISCOGS_TOKEN")
-
-	req.Header.Set("Authorization", "Discogs token="+discogsToken)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Authorization", "Discogs token="+c.Token)
 
 	// Take/check limiter before each request
-	limiter.Take()
+	c.wait()
 
-	resp, err := client.Do(req)
+	endpoint := apiEndpoint(url)
+	start := time.Now()
+
+	resp, err := c.HTTPClient.Do(req)
+	apiRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
 	if err != nil {
+		apiRequests.WithLabelValues(endpoint, "error").Inc()
 		return nil, err
-	} else if resp.StatusCode == http.StatusTooManyRequests {
+	}
+
+	apiRequests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		apiRateLimited.Inc()
+
+		if retries >= apiMaxRetries {
+			resp.Body.Close()
+			return nil, &APIError{Kind: ErrorRateLimited, URL: url, StatusCode: resp.StatusCode}
+		}
+		resp.Body.Close()
+
 		log.Warnf("Too many requests for %s, waiting to retry", url)
 
 		// Sleep to reset otherwise it overloads limit
 		time.Sleep(2 * time.Second)
 
 		// Take another to compensate
-		limiter.Take()
+		c.wait()
 
 		// Retry request
-		return AuthenticatedRequest(url)
+		apiRetries.Inc()
+		return c.authenticatedRequest(url, header, retries+1)
 	}
 
 	return resp, nil
 }
 
+// getJSON makes an authenticated request to url and decodes the json
+// response into v. Unsuccessful responses are returned as an APIError
+func (c *Client) getJSON(url string, v interface{}) error {
+	resp, err := c.AuthenticatedRequest(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, url); err != nil {
+		return err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &APIError{Kind: ErrorDecode, URL: url, Err: err}
+	}
+
+	return nil
+}
+
+// wait blocks until the rate limiter allows another request
+func (c *Client) wait() {
+	start := time.Now()
+	c.limiter.Take()
+	rateLimiterWait.Observe(time.Since(start).Seconds())
+}
+
 // GetFilteredUserLists takes a user list api url and returns a
 // slice of lists.
 // The response is paginated so we loop and append
 // until no further pagination is available
 // We then filter the results by which we want notifications for
-func GetFilteredUserLists(url string) ([]UserList, error) {
+func (c *Client) GetFilteredUserLists(url string) ([]UserList, error) {
 
 	userLists := []UserList{}
 
 	for true {
-		resp, err := AuthenticatedRequest(url)
-		if err != nil {
-			return nil, err
-		}
-
 		var data UserListsResponse
 
 		// Decode request response into UserListsResponse
-		err = json.NewDecoder(resp.Body).Decode(&data)
+		err := c.getJSON(url, &data)
 		if err != nil {
 			return nil, err
 		}
@@ -84,7 +164,6 @@ func GetFilteredUserLists(url string) ([]UserList, error) {
 		// Check if pagination provides a Next url to use
 		nextURL := data.Pagination.Urls.Next
 		if nextURL != "" {
-			fmt.Println(data)
 			url = nextURL
 		} else {
 			// Exit loop if not
@@ -99,40 +178,78 @@ func GetFilteredUserLists(url string) ([]UserList, error) {
 
 // GetListItems takes a list api url and returns a slice
 // of items in this list (ListItem)
-func GetListItems(url string) ([]ListItem, error) {
+func (c *Client) GetListItems(url string) ([]ListItem, error) {
 	var data ListResponse
 
-	resp, err := AuthenticatedRequest(url)
+	err := c.getJSON(url, &data)
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
+	return data.Items, nil
+}
+
+// ListVersion identifies the contents of a list fetched from discogs, it
+// is sent back in conditional requests
+type ListVersion struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// GetListIfModified fetches a list unless it is unchanged since version,
+// using If-None-Match/If-Modified-Since where discogs supports them.
+// A nil response is returned if the list is not modified
+func (c *Client) GetListIfModified(url string, version ListVersion) (*ListResponse, ListVersion, error) {
+	header := http.Header{}
+	if version.ETag != "" {
+		header.Set("If-None-Match", version.ETag)
+	}
+	if version.LastModified != "" {
+		header.Set("If-Modified-Since", version.LastModified)
+	}
+
+	resp, err := c.authenticatedRequest(url, header, 0)
 	if err != nil {
-		return nil, err
+		return nil, version, err
 	}
+	defer resp.Body.Close()
 
-	return data.Items, nil
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, version, nil
+	}
+
+	if err := checkResponse(resp, url); err != nil {
+		return nil, version, err
+	}
+
+	var data ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, version, &APIError{Kind: ErrorDecode, URL: url, Err: err}
+	}
+
+	return &data, ListVersion{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
 }
 
 // GetMarketItem takes a list item and url prefix and returns the marketplace
 // statistics for this item.
-func GetMarketItem(listItem ListItem, urlPrefix string) (*MarketItem, error) {
+func (c *Client) GetMarketItem(listItem ListItem, urlPrefix string) (*MarketItem, error) {
 	var data MarketResponse
 
-	url := fmt.Sprintf("%s%d?%s", urlPrefix, listItem.ID, os.Getenv("CURRENCY"))
+	url := fmt.Sprintf("%s%d?curr_abbr=%s", urlPrefix, listItem.ID, c.Currency)
 
-	resp, err := AuthenticatedRequest(url)
+	err := c.getJSON(url, &data)
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return nil, err
+	if data.Blocked {
+		return nil, &APIError{Kind: ErrorBlocked, URL: url}
 	}
 
-	minimumPrice, err := ParseComment(listItem.Comment)
+	rules, err := ParseRules(listItem.Comment)
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +257,8 @@ func GetMarketItem(listItem ListItem, urlPrefix string) (*MarketItem, error) {
 	item := MarketItem{
 		ID:           listItem.ID,
 		NumForSale:   data.NumForSale,
-		MinimumPrice: minimumPrice,
+		MinimumPrice: rules.MaxPrice,
+		Rules:        rules,
 		LowestPrice:  data.LowestPrice.Value,
 		Name:         listItem.Title,
 		URL:          listItem.URL,
@@ -150,17 +268,35 @@ func GetMarketItem(listItem ListItem, urlPrefix string) (*MarketItem, error) {
 	return &item, nil
 }
 
+// GetPriceSuggestions takes a release ID and url prefix and returns
+// discogs' suggested prices of the release for each media condition
+func (c *Client) GetPriceSuggestions(id int, urlPrefix string) (map[Grading]LowestPrice, error) {
+	var data map[string]LowestPrice
+
+	err := c.getJSON(fmt.Sprintf("%s%d", urlPrefix, id), &data)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := map[Grading]LowestPrice{}
+	for condition, price := range data {
+		grading, err := ParseGrading(condition)
+		if err != nil {
+			log.Debugf("Ignoring price suggestion for unknown condition '%s'", condition)
+			continue
+		}
+		suggestions[grading] = price
+	}
+
+	return suggestions, nil
+}
+
 // ParseComment takes a string and parses it for a float value
 // to be used as our minimum price (defaults to 0)
 func ParseComment(comment string) (minimumPrice float64, err error) {
+	rules, err := ParseRules(comment)
 
-	// Parse entire comment as float
-	// TODO: Add more robust parsing
-	if comment != "" {
-		minimumPrice, err = strconv.ParseFloat(comment, 32)
-	}
-
-	return
+	return rules.MaxPrice, err
 }
 
 // FilterNotifyUserLists takes a slice of userLists and returns a
@@ -179,104 +315,679 @@ func FilterNotifyUserLists(userLists []UserList) []UserList {
 
 // NotifyCheck takes a marketItem and the previous version of the
 // marketItem and returns a boolean of whether the user should be
-// notified of a new market listing
+// notified of a new market listing. Relative thresholds use the median
+// lowest price and price suggestions set on marketItem
 func NotifyCheck(marketItem, previousMarketItem MarketItem) bool {
+	return Passed(EvaluateItem(marketItem, &previousMarketItem))
+}
 
-	// Check if number of items for sale has increased
-	if marketItem.NumForSale <= previousMarketItem.NumForSale {
-		return false
-	}
+// Notify queues a notification of a new market item for each channel.
+// Keys (listings or prices) a recipient has already been notified of are
+// skipped, as are items still in their cooldown window
+func Notify(marketItem MarketItem, channels []Channel, deduper *Deduper, outbox *Outbox) {
+	log.Infof("New listing found for %s", marketItem.Name)
+
+	for _, channel := range channels {
+		recipient := channel.Recipient()
+
+		keys := deduper.Filter(recipient, marketItem.ID, NotificationKeys(marketItem), time.Now())
+		if len(keys) == 0 {
+			log.Debugf("Already notified %s of %s, skipping", recipient, marketItem.Name)
+			continue
+		}
 
-	// Check if a minimum price threshold is set and if the lowest price meets it
-	if marketItem.MinimumPrice > 0 && marketItem.LowestPrice > marketItem.MinimumPrice {
-		return false
+		// Only include the listings this recipient hasn't seen
+		notifyItem := marketItem
+		if len(notifyItem.Listings) > 0 {
+			notifyItem.Listings = filterListingsByKeys(notifyItem.Listings, keys)
+		}
+
+		err := outbox.Enqueue(Notification{
+			Event:     EventNewListing,
+			Channel:   channel.Name(),
+			Recipient: recipient,
+			Keys:      keys,
+			Item:      notifyItem,
+		})
+		if err != nil {
+			log.Errorf("Unable to queue notification for %s due to %v", marketItem.Name, err)
+			continue
+		}
+
+		// Record once queued so the next cycle doesn't queue it again, the
+		// dispatcher forgets the keys if the notification is dead lettered
+		err = deduper.Record(recipient, marketItem.ID, keys, time.Now())
+		if err != nil {
+			log.Errorf("Unable to record notification for %s due to %v", marketItem.Name, err)
+		}
 	}
+}
+
+// Notifier watches the marketplace for items in the user's lists and
+// notifies them of new listings
+type Notifier struct {
+	Config   *Config
+	Client   *Client
+	Channels []Channel
 
-	return true
+	previousMarketItems map[int]MarketItem
+	adminChannels       []Channel
+	health              *Health
+	quarantine          *Quarantine
+	decisions           *DecisionLog
+	deduper             *Deduper
+	releases            *ReleaseCache
+	history             *PriceHistory
+	lists               *ListCache
+	outbox              *Outbox
+	dispatcher          *Dispatcher
+
+	// parseAlerted is set once the admin is alerted of parse problems,
+	// until a cycle scrapes listings without problems
+	parseAlerted bool
 }
 
-// Notify creates and sends an email to the user of a new item
-func Notify(marketItem MarketItem) error {
-	log.Infof("New listing found for %s", marketItem.Name)
+// NewNotifier creates a Notifier from the config, loading any state
+// persisted in the data directory
+func NewNotifier(config *Config) (*Notifier, error) {
+	n := &Notifier{
+		Config:              config,
+		Client:              NewClient(config.Discogs),
+		previousMarketItems: map[int]MarketItem{},
+		health:              NewHealth(time.Now()),
+	}
 
-	msg, err := marketItem.CreateEmailMessage()
+	var err error
+
+	n.Client.Selectors, err = LoadSelectorProfile(config.Scraper.Selectors)
+	if err != nil {
+		return nil, err
+	}
+	n.Client.ScrapeMaxPages = config.Scraper.MaxPages
+
+	n.deduper, err = NewDeduper(filepath.Join(config.DataDir, "dedupe.json"), time.Duration(config.Notify.Cooldown))
+	if err != nil {
+		return nil, err
+	}
+
+	n.releases, err = NewReleaseCache(filepath.Join(config.DataDir, "releases.json"), time.Duration(config.ReleaseCacheTTL), func(id int) (*Release, error) {
+		return n.Client.GetRelease(id, config.Discogs.APIURL+"/releases/")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n.history, err = NewPriceHistory(filepath.Join(config.DataDir, "history.json"), time.Duration(config.PriceHistory))
+	if err != nil {
+		return nil, err
+	}
+
+	n.lists, err = NewListCache(filepath.Join(config.DataDir, "lists.json"), n.Client.GetListIfModified)
+	if err != nil {
+		return nil, err
+	}
+
+	n.outbox, err = NewOutbox(filepath.Join(config.DataDir, "outbox.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := readJSONFile(n.statePath(), &n.previousMarketItems); err != nil {
+		return nil, err
+	}
+
+	n.quarantine, err = NewQuarantine(filepath.Join(config.DataDir, "failures.json"), config.Notify.QuarantineAfter, time.Duration(config.Notify.QuarantineFor))
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Notify.DryRun {
+		path := config.Notify.DecisionLog
+		if path == "" {
+			path = filepath.Join(config.DataDir, "decisions.jsonl")
+		}
+		n.decisions = NewDecisionLog(path)
+	}
+
+	templates := NewTemplates(config.TemplateDir)
+
+	if len(config.SMTP.To) > 0 || len(config.SMTP.Bcc) > 0 {
+		transport, err := NewSMTPTransport(config.SMTP)
+		if err != nil {
+			return nil, err
+		}
+
+		n.Channels = append(n.Channels, EmailChannel{
+			Transport:       transport,
+			Templates:       templates,
+			ListUnsubscribe: config.SMTP.ListUnsubscribe,
+		})
+	}
+
+	if config.Chat.WebhookURL != "" {
+		n.Channels = append(n.Channels, NewChatChannel(config.Chat.WebhookURL, templates))
+	}
+
+	// Alert the admin by email if set, otherwise through every channel
+	n.adminChannels = n.Channels
+	channels := n.Channels
+	if len(config.Admin.Email) > 0 {
+		adminConfig := config.SMTP
+		adminConfig.To = config.Admin.Email
+		adminConfig.Bcc = nil
+
+		transport, err := NewSMTPTransport(adminConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		admin := EmailChannel{
+			Transport:   transport,
+			Templates:   templates,
+			ChannelName: "admin_email",
+		}
+		n.adminChannels = []Channel{admin}
+		channels = append(append([]Channel{}, n.Channels...), admin)
+	}
+
+	n.dispatcher = NewDispatcher(n.outbox, channels)
+	n.dispatcher.Deduper = n.deduper
+	n.dispatcher.Workers = config.Notify.Workers
+	n.dispatcher.MaxAttempts = config.Notify.MaxAttempts
+	n.dispatcher.RetryBase = time.Duration(config.Notify.RetryBase)
+	n.dispatcher.RetryMax = time.Duration(config.Notify.RetryMax)
+
+	return n, nil
+}
+
+// RunNotifier creates a Notifier from the config and runs it, serving
+// its metrics and health endpoints on the configured port
+func RunNotifier(config *Config) error {
+	n, err := NewNotifier(config)
 	if err != nil {
 		return err
 	}
 
-	return SendEmail(msg)
+	go func() {
+		addr := fmt.Sprintf(":%d", config.Port)
+		log.Infof("Serving metrics and health checks on %s", addr)
+
+		if err := http.ListenAndServe(addr, n.Handler()); err != nil {
+			log.Errorf("Unable to serve metrics and health checks due to %v", err)
+		}
+	}()
+
+	return n.Run()
 }
 
-type Notifier struct {
-	previousMarketItems map[int]MarketItem
-	rateLimiter         rate.Limiter
+// userListsURL returns the api url of the user's lists
+func (n *Notifier) userListsURL() string {
+	return fmt.Sprintf("%s/users/%s/lists", n.Config.Discogs.APIURL, n.Config.Discogs.Username)
 }
 
-// RunNotifier is the main logic loop for the program.
-//
-// It first finds all the lists of the user that they want notifications for
-// 		For each list it retrieves the items in that list
-//			For each list item it retrieves its marketplace stats
-//          If this item satisfies our notify conditions, notify user
-//          Store these marketplace stats to compare with our next loop
-func RunNotifier() error {
+// statePath returns the file the previous market items are persisted to
+func (n *Notifier) statePath() string {
+	return filepath.Join(n.Config.DataDir, "state.json")
+}
 
-	userListsURL := fmt.Sprintf("https://api.discogs.com/users/%s/lists", os.Getenv("DISCOGS_USERNAME"))
+// WatchedList is a list the user wants notifications for, with the rules
+// from its description and its items. Err is set if the items couldn't
+// be fetched
+type WatchedList struct {
+	List  UserList
+	Rules Rules
+	Items []ListItem
+	Err   error
+}
+
+// WatchedLists returns the lists of the user that they want notifications
+// for along with their items. Lists whose items can't be fetched are
+// logged and returned with their error
+func (n *Notifier) WatchedLists() ([]WatchedList, error) {
+	userLists, err := n.Client.GetFilteredUserLists(n.userListsURL())
+	if err != nil {
+		return nil, err
+	}
 
-	previousMarketItems := map[int]MarketItem{}
+	return n.fetchLists(context.Background(), userLists), nil
+}
 
-	log.Debugf("Running notifier for '%s'", os.Getenv("DISCOGS_USERNAME"))
+// MarketItem fetches the marketplace statistics of a list item, applying
+// its rules over the rules of its list and enriching it with its release
+func (n *Notifier) MarketItem(item ListItem, listRules Rules) (*MarketItem, error) {
+	log.Debugf("Fetching item '%s'", item.Title)
 
-	for true {
-		// Get lists from the user we want to be notified by
-		userLists, err := GetFilteredUserLists(userListsURL)
+	marketItem, err := n.Client.GetMarketItem(item, n.Config.Discogs.APIURL+"/marketplace/stats/")
+	if err != nil {
+		return nil, err
+	}
+
+	marketItem.Rules = listRules.Merge(marketItem.Rules)
+	marketItem.MinimumPrice = marketItem.Rules.MaxPrice
+
+	// Enrich releases with their metadata, other list item types
+	// (masters, artists, labels) have no release to fetch
+	if item.Type == "" || item.Type == "release" {
+		marketItem.Release, err = n.releases.Get(marketItem.ID)
 		if err != nil {
+			log.Warnf("Unable to get release metadata for %s due to %v", marketItem.Name, err)
+		}
+	}
+
+	marketItem.MedianLowestPrice, _ = n.history.Median(marketItem.ID, time.Now())
+
+	return marketItem, nil
+}
+
+// priceSuggestions fetches discogs' suggested prices of a market item for
+// its listings to be checked against. Suggestions cost a request so are
+// only fetched for releases with listings and a rule using them
+func (n *Notifier) priceSuggestions(item ListItem, marketItem *MarketItem, listings []ListedItem) {
+	if marketItem.Rules.SuggestedPercent <= 0 || len(listings) == 0 || (item.Type != "" && item.Type != "release") {
+		return
+	}
+
+	var err error
+	marketItem.PriceSuggestions, err = n.Client.GetPriceSuggestions(marketItem.ID, n.Config.Discogs.APIURL+"/marketplace/price_suggestions/")
+	if err != nil {
+		log.Warnf("Unable to get price suggestions for %s due to %v", marketItem.Name, err)
+	}
+}
+
+// CycleReport summarises a cycle, failures are counted rather than
+// stopping the cycle
+type CycleReport struct {
+	Lists                int
+	ListFailures         int
+	Items                int
+	ItemFailures         int
+	NotificationFailures int
+	// Quarantined are items skipped due to persistent failures
+	Quarantined int
+	// NewlyQuarantined are items which started being skipped this cycle
+	NewlyQuarantined int
+	// ParseProblems are problems scraping listings e.g. missing fields
+	ParseProblems int
+}
+
+// Failures returns the number of lists, items and notifications which failed
+func (r CycleReport) Failures() int {
+	return r.ListFailures + r.ItemFailures + r.NotificationFailures
+}
+
+// RunCycle checks every item in the user's watched lists once.
+//
+// For each list item it retrieves its marketplace stats
+// If this item satisfies our notify conditions, notify user
+// Store these marketplace stats to compare with our next cycle
+//
+// Lists, stats and listings are fetched concurrently by the pipeline,
+// results are then handled one at a time in list order
+//
+// If ctx is done the cycle stops early, still storing the stats of the
+// items checked
+func (n *Notifier) RunCycle(ctx context.Context) (CycleReport, error) {
+	report := CycleReport{}
+
+	start := time.Now()
+	defer func() {
+		cycleDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	userLists, err := n.Client.GetFilteredUserLists(n.userListsURL())
+	if err != nil {
+		return report, err
+	}
+
+	items := []*itemResult{}
+	for _, list := range n.fetchLists(ctx, userLists) {
+		report.Lists++
+		if list.Err != nil {
+			report.ListFailures++
+			continue
+		}
+
+		for _, item := range list.Items {
+			if n.quarantine.Skip(item.ID, time.Now()) {
+				log.Debugf("Skipping quarantined item '%s'", item.Title)
+				report.Quarantined++
+				continue
+			}
+
+			items = append(items, &itemResult{index: len(items), item: item, listRules: list.Rules})
+		}
+	}
+
+	// Results are handled in list order so notifications are queued in
+	// the same order every cycle
+	scraped := false
+	parseProblems := []string{}
+	for _, result := range n.runPipeline(ctx, items) {
+		report.Items++
+		n.finishItem(result, &report)
+
+		scraped = scraped || result.scraped
+		for _, problem := range result.parseProblems() {
+			parseProblems = append(parseProblems, fmt.Sprintf("%s (%d): %s", result.marketItem.Name, result.marketItem.ID, problem))
+		}
+	}
+
+	report.ParseProblems = len(parseProblems)
+	n.checkParseHealth(scraped, parseProblems)
+
+	quarantinedItems.Set(float64(len(n.quarantine.Quarantined(time.Now()))))
+
+	// Summarise every broken item when any more break
+	if report.NewlyQuarantined > 0 {
+		n.Alert(n.quarantine.Summary(time.Now()))
+	}
+
+	if err := writeJSONFile(n.statePath(), n.previousMarketItems); err != nil {
+		return report, err
+	}
+
+	if err := n.history.Save(); err != nil {
+		return report, err
+	}
+
+	return report, ctx.Err()
+}
+
+// checkParseHealth alerts the admin when scraped sell pages stop parsing,
+// which usually means discogs has changed its markup and the selector
+// profile needs updating. The admin is alerted once until a cycle's
+// scrapes parse cleanly again
+func (n *Notifier) checkParseHealth(scraped bool, problems []string) {
+	for _, problem := range problems {
+		log.Warnf("Scrape parse problem with %s", problem)
+	}
+
+	if len(problems) == 0 {
+		if scraped {
+			n.parseAlerted = false
+		}
+		return
+	}
+
+	if n.parseAlerted {
+		return
+	}
+	n.parseAlerted = true
+
+	n.Alert(fmt.Sprintf("Marketplace listings are not parsing correctly, the selector profile (revision %s) may need updating:\n\n- %s\n",
+		n.Client.Selectors.Revision, strings.Join(problems, "\n- ")))
+}
+
+// itemFailed counts a failure of an item, quarantining it if it keeps
+// failing
+func (n *Notifier) itemFailed(item ListItem, err error, report *CycleReport) {
+	kind := ErrorKindOf(err)
+
+	log.Errorf("Error getting market item for %s (%s) due to %v", item.Title, kind, err)
+	report.ItemFailures++
+	itemFailures.WithLabelValues(string(kind)).Inc()
+
+	quarantined, saveErr := n.quarantine.RecordFailure(item.ID, item.Title, err, time.Now())
+	if saveErr != nil {
+		log.Errorf("Unable to record failure of %s due to %v", item.Title, saveErr)
+	}
+
+	if quarantined {
+		log.Warnf("Quarantining %s after repeated failures", item.Title)
+		report.NewlyQuarantined++
+	}
+}
+
+// dryRunNotify returns the decisions of who would be notified of a market
+// item without queueing or recording anything
+func (n *Notifier) dryRunNotify(marketItem MarketItem) []Decision {
+	decisions := []Decision{}
+
+	for _, channel := range n.Channels {
+		recipient := channel.Recipient()
+
+		keys := n.deduper.Filter(recipient, marketItem.ID, NotificationKeys(marketItem), time.Now())
+
+		dedupe := RuleResult{
+			Rule:   "dedupe",
+			Passed: len(keys) > 0,
+			Reason: fmt.Sprintf("%d new of %d", len(keys), len(NotificationKeys(marketItem))),
+		}
+		if !dedupe.Passed {
+			dedupe.Reason = "already notified or in cooldown"
+		}
+
+		decisions = append(decisions, Decision{
+			Time:      time.Now(),
+			ReleaseID: marketItem.ID,
+			Name:      marketItem.Name,
+			Recipient: recipient,
+			Notify:    dedupe.Passed,
+			Rules:     []RuleResult{dedupe},
+		})
+
+		if dedupe.Passed {
+			log.Infof("Dry run, would notify %s of %s", recipient, marketItem.Name)
+		}
+	}
+
+	return decisions
+}
+
+// Delay before running another cycle after a cycle fails
+var cycleRetryDelay = time.Minute
+
+// Run is the main logic loop for the program, running cycles until the
+// token is rejected. Queued notifications are delivered in the background
+// (nothing is delivered in a dry run)
+func (n *Notifier) Run() error {
+	if !n.Config.Notify.DryRun {
+		go n.dispatcher.Run(context.Background())
+	}
+
+	if threshold := time.Duration(n.Config.Health.StallThreshold); threshold > 0 {
+		go n.watch(context.Background(), threshold)
+	}
+
+	log.Debugf("Running notifier for '%s'", n.Config.Discogs.Username)
+
+	for {
+		report, err := n.RunCycle(context.Background())
+		n.finishCycle(report, err)
+
+		// A bad token won't fix itself, anything else (e.g. discogs being
+		// unavailable) is retried after a delay
+		if ErrorKindOf(err) == ErrorUnauthorized {
 			return err
+		} else if err != nil {
+			log.Errorf("Cycle failed due to %v, retrying in %s", err, cycleRetryDelay)
+			time.Sleep(cycleRetryDelay)
 		}
+	}
+}
+
+// finishCycle records the outcome of a cycle and pings the heartbeat url
+// if it succeeded
+func (n *Notifier) finishCycle(report CycleReport, err error) {
+	if !n.health.RecordCycle(report, err, time.Now()) || n.Config.Health.HeartbeatURL == "" {
+		return
+	}
+
+	if err := Heartbeat(n.Client.HTTPClient, n.Config.Health.HeartbeatURL); err != nil {
+		log.Warnf("Unable to send heartbeat due to %v", err)
+	}
+}
+
+// watch alerts the admin if no cycle succeeds within threshold, checking
+// periodically until ctx is done. The cycle loop may be stuck so this
+// runs separately from it
+func (n *Notifier) watch(ctx context.Context, threshold time.Duration) {
+	interval := threshold / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
 
-		for _, list := range userLists {
-			log.Debugf("Fetching list '%s'", list.Name)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-			// Get the items found in each list
-			items, err := GetListItems(list.ResourceURL)
-			if err != nil {
-				log.Errorf("Error getting list items for %s due to %v", list.Name, err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !n.health.ShouldAlert(threshold, now) {
 				continue
 			}
 
-			for _, item := range items {
-				log.Debugf("Fetching item '%s'", item.Title)
+			message := fmt.Sprintf("No cycle has completed successfully in the last %s.", threshold)
+			if status := n.health.Status(""); status.LastError != "" {
+				message += " The last error was: " + status.LastError
+			}
 
-				// Get the marketplace statistics for each item in the list
-				marketItem, err := GetMarketItem(item, "https://api.discogs.com/marketplace/stats/")
-				if err != nil {
-					log.Errorf("Error getting market items for %s due to %v", marketItem.Name, err)
-					continue
-				}
+			n.Alert(message)
+		}
+	}
+}
 
-				// Compare marketplace statistics with previous stats
-				// Only compare and notify if a previous item exists in the map
-				// (don't notify on first run)
-				if previousMarketItem, ok := previousMarketItems[marketItem.ID]; ok {
-					if NotifyCheck(*marketItem, previousMarketItem) {
+// Alert queues an admin alert through each admin channel. In a dry run
+// alerts are only logged
+func (n *Notifier) Alert(message string) {
+	log.Errorf("Admin alert: %s", message)
 
-						go func() {
-							err = Notify(*marketItem)
-							if err != nil {
-								log.Errorf("Unable to notify new listing for %s due to %v", marketItem.Name, err)
-							}
-						}()
+	if n.Config.Notify.DryRun {
+		return
+	}
 
-					}
-				}
+	for _, channel := range n.adminChannels {
+		err := n.outbox.Enqueue(Notification{
+			Event:     EventAdminAlert,
+			Channel:   channel.Name(),
+			Recipient: channel.Recipient(),
+			Message:   message,
+		})
+		if err != nil {
+			log.Errorf("Unable to queue admin alert due to %v", err)
+		}
+	}
+}
+
+// Once runs a single cycle, for running as a scheduled job. The cycle is
+// bounded by the job timeout, then the notifications it queued (along with
+// any already due) are delivered within the job flush timeout. A timeout
+// of 0 is unbounded.
+//
+// Delivery failures are counted in the report, failed notifications stay
+// in the outbox to be retried by the next job
+func (n *Notifier) Once(ctx context.Context) (CycleReport, error) {
+	cycleCtx := ctx
+	if timeout := time.Duration(n.Config.Job.Timeout); timeout > 0 {
+		var cancel context.CancelFunc
+		cycleCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	report, err := n.RunCycle(cycleCtx)
+	n.finishCycle(report, err)
+
+	if !n.Config.Notify.DryRun {
+		flushCtx := ctx
+		if timeout := time.Duration(n.Config.Job.FlushTimeout); timeout > 0 {
+			var cancel context.CancelFunc
+			flushCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		report.NotificationFailures = n.dispatcher.Flush(flushCtx)
+	}
 
-				// Add new marketplace statistics regardless of previous logic outcome
-				previousMarketItems[marketItem.ID] = *marketItem
+	log.Infof("Checked %d items in %d lists, %d list, %d item and %d notification failures, %d scrape parse problems",
+		report.Items, report.Lists, report.ListFailures, report.ItemFailures, report.NotificationFailures, report.ParseProblems)
+
+	return report, err
+}
+
+// CheckResult is what would be notified for a release
+type CheckResult struct {
+	Item *MarketItem
+	// List is the watched list containing the release, nil if not watched
+	List *UserList
+	// Listings are all the scraped listings of the release
+	Listings []ListedItem
+	// Matches are the listings satisfying the release's rules
+	Matches []ListedItem
+	// ItemRules are the results of the rules checked against the release's
+	// stats and its previous stats, Listings that of its matches
+	ItemRules    []RuleResult
+	ListingsRule RuleResult
+	// Notify is whether the release would be notified
+	Notify bool
+	// ParseProblems are problems scraping the listings e.g. missing fields
+	ParseProblems []string
+	// Unnotified are the keys each recipient hasn't yet been notified of
+	Unnotified map[string][]string
+}
+
+// Check fetches the marketplace stats and scraped listings of a release
+// and returns what would be notified, using the rules of the release if
+// it's in a watched list. Nothing is sent or recorded
+func (n *Notifier) Check(releaseID int) (*CheckResult, error) {
+	result := &CheckResult{Unnotified: map[string][]string{}}
+
+	listItem := ListItem{ID: releaseID, Title: strconv.Itoa(releaseID)}
+	listRules := Rules{}
+
+	lists, err := n.WatchedLists()
+	if err != nil {
+		return nil, err
+	}
 
-				log.Debugf("Updated market item %v", *marketItem)
+	for _, list := range lists {
+		for _, item := range list.Items {
+			if item.ID == releaseID {
+				list := list
+				result.List = &list.List
+				listItem, listRules = item, list.Rules
 			}
 		}
 	}
 
-	return nil
+	result.Item, err = n.MarketItem(listItem, listRules)
+	if err != nil {
+		return nil, err
+	}
+
+	var parse ParseReport
+	result.Listings, parse, err = n.Client.ScrapeListedItems(context.Background(), strconv.Itoa(releaseID), nil)
+	if err != nil {
+		return nil, err
+	}
+	result.ParseProblems = parse.Problems(result.Item.NumForSale)
+	n.Config.Imports.EstimateLandedCosts(result.Listings)
+	n.priceSuggestions(listItem, result.Item, result.Listings)
+
+	result.Matches = []ListedItem{}
+	for _, listing := range result.Listings {
+		if Passed(EvaluateListing(listing, *result.Item)) {
+			result.Matches = append(result.Matches, listing)
+		}
+	}
+
+	item := *result.Item
+	item.Listings = result.Matches
+
+	var previous *MarketItem
+	if previousMarketItem, ok := n.previousMarketItems[item.ID]; ok {
+		previous = &previousMarketItem
+	}
+
+	result.ItemRules = EvaluateItem(item, previous)
+	result.ListingsRule = ListingsRule(item, len(result.Listings), true)
+	result.Notify = Passed(result.ItemRules) && result.ListingsRule.Passed
+
+	for _, channel := range n.Channels {
+		recipient := channel.Recipient()
+		result.Unnotified[recipient] = n.deduper.Filter(recipient, item.ID, NotificationKeys(item), time.Now())
+	}
+
+	return result, nil
 }
@@ -1,7 +1,9 @@
 package notifier
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -11,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	rate "go.uber.org/ratelimit"
 )
 
 func MockJsonHandler(t *testing.T, v interface{}) http.HandlerFunc {
@@ -45,22 +48,23 @@ func MockServerWithURL(URL string, handler http.Handler) (*httptest.Server, erro
 var token = "MY_TOKEN"
 var tokenHeader = "Discogs token=" + token
 
-func TestAuthenticatedRequest(t *testing.T) {
-	os.Setenv("DISCOGS_TOKEN", token)
+// testClient returns a client authenticated with the test token
+func testClient() *Client {
+	return NewClient(DiscogsConfig{Token: token, Currency: "AUD"})
+}
 
+func TestAuthenticatedRequest(t *testing.T) {
 	ts := httptest.NewServer(MockJsonHandler(t, ""))
 
 	defer ts.Close()
 
-	_, err := AuthenticatedRequest(ts.URL)
+	_, err := testClient().AuthenticatedRequest(ts.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestGetListItems(t *testing.T) {
-	os.Setenv("DISCOGS_TOKEN", token)
-
 	responseData := ListResponse{
 		ID:          0,
 		Name:        "Test List",
@@ -93,7 +97,7 @@ func TestGetListItems(t *testing.T) {
 	defer ts.Close()
 
 	// Call server to get list items
-	items, err := GetListItems(ts.URL)
+	items, err := testClient().GetListItems(ts.URL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,7 +113,6 @@ func TestGetListItems(t *testing.T) {
 func TestGetFilteredUserLists(t *testing.T) {
 	url := "127.0.0.1:35357"
 	nextPath := "/item2"
-	os.Setenv("DISCOGS_TOKEN", token)
 
 	responseData1 := UserListsResponse{
 		Pagination: Pagination{
@@ -164,7 +167,7 @@ func TestGetFilteredUserLists(t *testing.T) {
 	}
 
 	// Call server to get and filter lists
-	userLists, err := GetFilteredUserLists(ts.URL)
+	userLists, err := testClient().GetFilteredUserLists(ts.URL)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -223,9 +226,6 @@ func TestFilterNotifyUserLists(t *testing.T) {
 func TestGetMarketItem(t *testing.T) {
 	currency := "AUD"
 
-	os.Setenv("CURRENCY", currency)
-	os.Setenv("DISCOGS_TOKEN", token)
-
 	// Create response
 	responseData := MarketResponse{
 		LowestPrice: LowestPrice{
@@ -256,7 +256,7 @@ func TestGetMarketItem(t *testing.T) {
 	defer ts.Close()
 
 	// Use ts.URL as the prefix
-	marketItem, err := GetMarketItem(listItem, ts.URL+"/")
+	marketItem, err := NewClient(DiscogsConfig{Token: token, Currency: currency}).GetMarketItem(listItem, ts.URL+"/")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -273,6 +273,7 @@ func TestGetMarketItem(t *testing.T) {
 		ID:           listItem.ID,
 		NumForSale:   responseData.NumForSale,
 		MinimumPrice: minPrice,
+		Rules:        Rules{MaxPrice: minPrice},
 		LowestPrice:  responseData.LowestPrice.Value,
 		Name:         listItem.Title,
 		URL:          listItem.URL,
@@ -363,3 +364,177 @@ func TestParseComment(t *testing.T) {
 		}
 	}
 }
+
+// testNotifier returns a Notifier using a mock discogs api with two
+// watched lists, one of which fails, and their market stats
+func testNotifier(t *testing.T) *Notifier {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	mux.Handle("/users/digger/lists", MockJsonHandler(t, UserListsResponse{
+		Lists: []UserList{
+			UserList{ID: 1, Name: "Wanted", Description: "notify_me max=40", ResourceURL: ts.URL + "/lists/1"},
+			UserList{ID: 2, Name: "Broken", Description: "notify_me", ResourceURL: ts.URL + "/lists/2"},
+			UserList{ID: 3, Name: "Ignored", ResourceURL: ts.URL + "/lists/3"},
+		},
+	}))
+	mux.Handle("/lists/1", MockJsonHandler(t, ListResponse{
+		Items: []ListItem{
+			ListItem{ID: 10, Title: "Item 10", Comment: "max=20", Type: "master"},
+			ListItem{ID: 11, Title: "Item 11", Comment: "not a rule", Type: "master"},
+		},
+	}))
+	mux.HandleFunc("/lists/2", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	})
+	mux.Handle("/marketplace/stats/", MockJsonHandler(t, MarketResponse{
+		LowestPrice: LowestPrice{Currency: "AUD", Value: 25},
+		NumForSale:  3,
+	}))
+
+	config := DefaultConfig()
+	config.Discogs = DiscogsConfig{Username: "digger", Token: token, Currency: "AUD", APIURL: ts.URL}
+	config.DataDir = t.TempDir()
+
+	n, err := NewNotifier(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Don't rate limit requests to the mock api
+	n.Client.limiter = rate.NewUnlimited()
+
+	return n
+}
+
+func TestRunCycle(t *testing.T) {
+	n := testNotifier(t)
+
+	report, err := n.RunCycle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := CycleReport{Lists: 2, ListFailures: 1, Items: 2, ItemFailures: 1}
+	if report != expected {
+		t.Errorf("Expected report %v, got %v", expected, report)
+	}
+
+	// Item rules override the list rules
+	if item := n.previousMarketItems[10]; item.MinimumPrice != 20 || item.LowestPrice != 25 {
+		t.Errorf("Expected item with max price 20 and lowest price 25, got %v", item)
+	}
+
+	// Items failing every cycle are quarantined and skipped
+	n.quarantine.after = 2
+
+	report, err = n.RunCycle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.NewlyQuarantined != 1 || report.Items != 2 {
+		t.Errorf("Expected item 11 to be quarantined, got %v", report)
+	}
+
+	report, err = n.RunCycle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Quarantined != 1 || report.Items != 1 || report.ItemFailures != 0 {
+		t.Errorf("Expected quarantined item to be skipped, got %v", report)
+	}
+
+	// State is persisted for the next run
+	reloaded, err := NewNotifier(n.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := reloaded.previousMarketItems[10]; !ok {
+		t.Error("Expected previous market items to be loaded from state")
+	}
+}
+
+func TestOnceCancelled(t *testing.T) {
+	n := testNotifier(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := n.Once(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancelled error, got %v", err)
+	}
+
+	if report.Items != 0 {
+		t.Errorf("Expected no items checked, got %v", report)
+	}
+
+	if _, err := os.Stat(n.statePath()); err != nil {
+		t.Errorf("Expected state to be saved when stopped early, got %v", err)
+	}
+}
+
+func TestCheckParseHealth(t *testing.T) {
+	n := testNotifier(t)
+
+	n.checkParseHealth(true, []string{"Test (1): no listings found, 3 for sale"})
+	if !n.parseAlerted {
+		t.Error("Expected parse problems to alert the admin")
+	}
+
+	// Cycles without scrapes don't show the problem is fixed
+	n.checkParseHealth(false, nil)
+	if !n.parseAlerted {
+		t.Error("Expected alert to stand until listings are scraped without problems")
+	}
+
+	n.checkParseHealth(true, nil)
+	if n.parseAlerted {
+		t.Error("Expected alert to be reset by a clean scrape")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	n := testNotifier(t)
+	n.Channels = []Channel{&MockChannel{}}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sellPageHTML(false, "2", "1"))
+	}))
+	defer ts.Close()
+	n.Client.WebURL = ts.URL
+
+	// Listings are 20.00 after conversion, at the item's max price
+	result, err := n.Check(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.List == nil || result.List.Name != "Wanted" || len(result.Matches) != 2 || !result.ListingsRule.Passed {
+		t.Errorf("Expected both listings to match in list Wanted, got %v", result)
+	}
+
+	if result.Notify || result.ItemRules[0].Rule != "previous_stats" {
+		t.Errorf("Expected a release without previous stats not to be notified, got %v", result.ItemRules)
+	}
+
+	// The lowest price is over the max price even though listings match
+	n.previousMarketItems[10] = MarketItem{ID: 10, NumForSale: 1}
+
+	result, err = n.Check(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Notify || result.ItemRules[1].Reason != "lowest price 25.00, max 20.00" {
+		t.Errorf("Expected lowest price over max price not to be notified, got %v", result.ItemRules)
+	}
+
+	if keys := result.Unnotified["user@example.com"]; len(keys) != 2 {
+		t.Errorf("Expected a key for each matching listing, got %v", keys)
+	}
+}
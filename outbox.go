@@ -0,0 +1,385 @@
+package notifier
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NotificationState is the delivery state of a notification in the outbox
+type NotificationState string
+
+const (
+	// NotificationPending is waiting to be (re)delivered
+	NotificationPending NotificationState = "pending"
+	// NotificationDead has exhausted its delivery attempts
+	NotificationDead NotificationState = "dead"
+)
+
+// Notification is a single message queued for delivery to a recipient
+// through a channel
+type Notification struct {
+	ID          string            `json:"id"`
+	Event       Event             `json:"event"`
+	Channel     string            `json:"channel"`
+	Recipient   string            `json:"recipient"`
+	Keys        []string          `json:"keys"`
+	Item        MarketItem        `json:"item"`
+	Message     string            `json:"message,omitempty"`
+	State       NotificationState `json:"state"`
+	Attempts    int               `json:"attempts"`
+	CreatedAt   time.Time         `json:"created_at"`
+	NextAttempt time.Time         `json:"next_attempt"`
+	LastError   string            `json:"last_error,omitempty"`
+}
+
+// Channel delivers notifications to a recipient
+type Channel interface {
+	// Name identifies the channel, notifications are routed by this name
+	Name() string
+	// Recipient identifies who the channel delivers to
+	Recipient() string
+	// Send delivers a notification
+	Send(n Notification) error
+}
+
+// Outbox is a persistent queue of notifications. Notifications stay in
+// the outbox until they are delivered or dead lettered so nothing is lost
+// when sending fails or the program restarts
+type Outbox struct {
+	mu            sync.Mutex
+	path          string
+	notifications map[string]*Notification
+	inFlight      map[string]bool
+	wake          chan struct{}
+}
+
+// NewOutbox creates an Outbox persisted to path, loading any previously
+// queued notifications
+func NewOutbox(path string) (*Outbox, error) {
+	o := &Outbox{
+		path:          path,
+		notifications: map[string]*Notification{},
+		inFlight:      map[string]bool{},
+		wake:          make(chan struct{}, 1),
+	}
+
+	notifications := []*Notification{}
+	if err := readJSONFile(path, &notifications); err != nil {
+		return nil, err
+	}
+
+	for _, n := range notifications {
+		o.notifications[n.ID] = n
+	}
+
+	return o, nil
+}
+
+// newNotificationID returns a random hex ID
+func newNotificationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Enqueue adds a notification to the outbox to be delivered immediately
+func (o *Outbox) Enqueue(n Notification) error {
+	id, err := newNotificationID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	n.ID = id
+	n.State = NotificationPending
+	n.Attempts = 0
+	n.CreatedAt = now
+	n.NextAttempt = now
+
+	o.mu.Lock()
+	o.notifications[n.ID] = &n
+	err = o.save()
+	o.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Wake a waiting worker without blocking if one is already awake
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Notifications returns a copy of all notifications in the given state
+// ordered by creation time
+func (o *Outbox) Notifications(state NotificationState) []Notification {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	notifications := []Notification{}
+	for _, n := range o.sorted() {
+		if n.State == state {
+			notifications = append(notifications, *n)
+		}
+	}
+
+	return notifications
+}
+
+// claim returns the oldest pending notification due at now which is not
+// already being delivered, and marks it in flight
+func (o *Outbox) claim(now time.Time) (Notification, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, n := range o.sorted() {
+		if n.State != NotificationPending || o.inFlight[n.ID] || n.NextAttempt.After(now) {
+			continue
+		}
+
+		o.inFlight[n.ID] = true
+
+		return *n, true
+	}
+
+	return Notification{}, false
+}
+
+// complete removes a delivered notification from the outbox
+func (o *Outbox) complete(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.inFlight, id)
+	delete(o.notifications, id)
+
+	return o.save()
+}
+
+// fail records a failed delivery attempt. The notification is scheduled
+// for retry after backoff or dead lettered once maxAttempts is reached
+func (o *Outbox) fail(id string, sendErr error, now time.Time, backoff time.Duration, maxAttempts int) (Notification, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.inFlight, id)
+
+	n, ok := o.notifications[id]
+	if !ok {
+		return Notification{}, fmt.Errorf("notification %s not found", id)
+	}
+
+	n.Attempts++
+	n.LastError = sendErr.Error()
+	n.NextAttempt = now.Add(backoff)
+
+	if n.Attempts >= maxAttempts {
+		n.State = NotificationDead
+	}
+
+	return *n, o.save()
+}
+
+// sorted returns the notifications ordered by creation time.
+// Must be called with the lock held
+func (o *Outbox) sorted() []*Notification {
+	notifications := make([]*Notification, 0, len(o.notifications))
+	for _, n := range o.notifications {
+		notifications = append(notifications, n)
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		if notifications[i].CreatedAt.Equal(notifications[j].CreatedAt) {
+			return notifications[i].ID < notifications[j].ID
+		}
+		return notifications[i].CreatedAt.Before(notifications[j].CreatedAt)
+	})
+
+	return notifications
+}
+
+// save persists the outbox. Must be called with the lock held
+func (o *Outbox) save() error {
+	return writeJSONFile(o.path, o.sorted())
+}
+
+// Dispatcher delivers notifications from an Outbox through their
+// channels using a bounded pool of workers. Failed deliveries are retried
+// with exponential backoff until MaxAttempts is reached. Keys of dead
+// lettered notifications are forgotten by the Deduper, if set, so they
+// aren't treated as sent
+type Dispatcher struct {
+	Outbox       *Outbox
+	Channels     map[string]Channel
+	Deduper      *Deduper
+	Workers      int
+	MaxAttempts  int
+	RetryBase    time.Duration
+	RetryMax     time.Duration
+	PollInterval time.Duration
+}
+
+// NewDispatcher creates a Dispatcher for the outbox and channels with
+// default worker and retry settings
+func NewDispatcher(outbox *Outbox, channels []Channel) *Dispatcher {
+	d := &Dispatcher{
+		Outbox:       outbox,
+		Channels:     map[string]Channel{},
+		Workers:      2,
+		MaxAttempts:  8,
+		RetryBase:    30 * time.Second,
+		RetryMax:     time.Hour,
+		PollInterval: time.Second,
+	}
+
+	for _, channel := range channels {
+		d.Channels[channel.Name()] = channel
+	}
+
+	return d
+}
+
+// Backoff returns how long to wait before retrying a notification that
+// has failed the given number of attempts
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	backoff := d.RetryBase
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= d.RetryMax {
+			return d.RetryMax
+		}
+	}
+
+	return backoff
+}
+
+// Run starts the workers and blocks until ctx is cancelled and all
+// in flight deliveries have finished
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.start(ctx, false)
+}
+
+// Flush delivers every notification that is currently due and returns
+// the number of deliveries that failed once none are left or ctx is
+// cancelled. Failed notifications are left in the outbox to be retried
+func (d *Dispatcher) Flush(ctx context.Context) int {
+	return d.start(ctx, true)
+}
+
+// start runs the workers until they have finished, returning the number
+// of failed deliveries
+func (d *Dispatcher) start(ctx context.Context, drain bool) int {
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var failures int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			atomic.AddInt32(&failures, int32(d.work(ctx, drain)))
+		}()
+	}
+
+	wg.Wait()
+
+	return int(failures)
+}
+
+// work delivers due notifications until ctx is cancelled, waiting for
+// new notifications or the poll interval when there is nothing to do.
+// When draining it returns as soon as there is nothing to do.
+// Returns the number of failed deliveries
+func (d *Dispatcher) work(ctx context.Context, drain bool) int {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		if ctx.Err() != nil {
+			return failures
+		}
+
+		if n, ok := d.Outbox.claim(time.Now()); ok {
+			if !d.deliver(n) {
+				failures++
+			}
+			continue
+		}
+
+		if drain {
+			return failures
+		}
+
+		select {
+		case <-ctx.Done():
+			return failures
+		case <-d.Outbox.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver sends a single notification and records the outcome, returning
+// whether it was sent
+func (d *Dispatcher) deliver(n Notification) bool {
+	var err error
+
+	channel, ok := d.Channels[n.Channel]
+	if !ok {
+		err = fmt.Errorf("unknown channel '%s'", n.Channel)
+	} else {
+		err = channel.Send(n)
+	}
+
+	if err == nil {
+		notificationsSent.WithLabelValues(n.Channel).Inc()
+
+		log.Infof("Successfully notified %s of %s via %s", n.Recipient, n.Item.Name, n.Channel)
+
+		if err := d.Outbox.complete(n.ID); err != nil {
+			log.Errorf("Unable to remove notification %s from outbox due to %v", n.ID, err)
+		}
+		return true
+	}
+
+	notificationsFailed.WithLabelValues(n.Channel).Inc()
+
+	failed, saveErr := d.Outbox.fail(n.ID, err, time.Now(), d.Backoff(n.Attempts+1), d.MaxAttempts)
+	if saveErr != nil {
+		log.Errorf("Unable to update notification %s in outbox due to %v", n.ID, saveErr)
+	}
+
+	if failed.State == NotificationDead {
+		log.Errorf("Giving up notifying %s of %s after %d attempts due to %v", n.Recipient, n.Item.Name, failed.Attempts, err)
+
+		if d.Deduper != nil && len(n.Keys) > 0 {
+			if err := d.Deduper.Forget(n.Recipient, n.Item.ID, n.Keys); err != nil {
+				log.Errorf("Unable to forget notification %s due to %v", n.ID, err)
+			}
+		}
+	} else {
+		log.Warnf("Unable to notify %s of %s due to %v, retrying at %s", n.Recipient, n.Item.Name, err, failed.NextAttempt)
+	}
+
+	return false
+}
@@ -0,0 +1,266 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// MockChannel records sent notifications, failing the first Failures
+// sends and tracking the maximum number of concurrent sends
+type MockChannel struct {
+	Failures int32
+	Delay    time.Duration
+
+	mu            sync.Mutex
+	sent          []Notification
+	calls         int32
+	active        int32
+	maxConcurrent int32
+}
+
+func (c *MockChannel) Name() string {
+	return "mock"
+}
+
+func (c *MockChannel) Recipient() string {
+	return "user@example.com"
+}
+
+func (c *MockChannel) Send(n Notification) error {
+	active := atomic.AddInt32(&c.active, 1)
+	defer atomic.AddInt32(&c.active, -1)
+
+	for {
+		max := atomic.LoadInt32(&c.maxConcurrent)
+		if active <= max || atomic.CompareAndSwapInt32(&c.maxConcurrent, max, active) {
+			break
+		}
+	}
+
+	time.Sleep(c.Delay)
+
+	if atomic.AddInt32(&c.calls, 1) <= c.Failures {
+		return errors.New("mock failure")
+	}
+
+	c.mu.Lock()
+	c.sent = append(c.sent, n)
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *MockChannel) Sent() []Notification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Notification{}, c.sent...)
+}
+
+func testDispatcher(t *testing.T, channel Channel) *Dispatcher {
+	outbox, err := NewOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewDispatcher(outbox, []Channel{channel})
+	dispatcher.RetryBase = time.Millisecond
+	dispatcher.RetryMax = 5 * time.Millisecond
+	dispatcher.PollInterval = time.Millisecond
+
+	return dispatcher
+}
+
+// waitFor polls condition until it is true or the timeout is reached
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	channel := &MockChannel{Failures: 2}
+	dispatcher := testDispatcher(t, channel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+
+	err := dispatcher.Outbox.Enqueue(Notification{Channel: "mock", Item: MarketItem{ID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return len(channel.Sent()) == 1 })
+
+	cancel()
+	<-done
+
+	if calls := atomic.LoadInt32(&channel.calls); calls != 3 {
+		t.Errorf("Expected 3 send attempts, got %d", calls)
+	}
+
+	if pending := dispatcher.Outbox.Notifications(NotificationPending); len(pending) != 0 {
+		t.Errorf("Expected empty outbox after delivery, got %v", pending)
+	}
+}
+
+func TestDispatcherDeadLetter(t *testing.T) {
+	channel := &MockChannel{Failures: 100}
+	dispatcher := testDispatcher(t, channel)
+	dispatcher.MaxAttempts = 3
+
+	deduper, err := NewDeduper(filepath.Join(t.TempDir(), "dedupe.json"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Deduper = deduper
+
+	keys := []string{ListingKey("100")}
+	if err := deduper.Record(channel.Recipient(), 1, keys, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+
+	err = dispatcher.Outbox.Enqueue(Notification{
+		Channel:   "mock",
+		Recipient: channel.Recipient(),
+		Keys:      keys,
+		Item:      MarketItem{ID: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool { return len(dispatcher.Outbox.Notifications(NotificationDead)) == 1 })
+
+	cancel()
+	<-done
+
+	dead := dispatcher.Outbox.Notifications(NotificationDead)[0]
+	if dead.Attempts != 3 {
+		t.Errorf("Expected 3 attempts before dead lettering, got %d", dead.Attempts)
+	}
+	if dead.LastError != "mock failure" {
+		t.Errorf("Expected last error 'mock failure', got '%s'", dead.LastError)
+	}
+
+	// The undelivered keys can be notified again
+	if filtered := deduper.Filter(channel.Recipient(), 1, keys, time.Now()); !cmp.Equal(filtered, keys) {
+		t.Errorf("Expected dead lettered keys %v to be forgotten, got %v", keys, filtered)
+	}
+
+	// Reload the outbox to check the dead letter was persisted
+	outbox, err := NewOutbox(dispatcher.Outbox.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbox.Notifications(NotificationDead)) != 1 {
+		t.Error("Expected dead notification to be persisted")
+	}
+}
+
+func TestDispatcherFlush(t *testing.T) {
+	channel := &MockChannel{Failures: 1}
+	dispatcher := testDispatcher(t, channel)
+	dispatcher.RetryBase = time.Hour
+	dispatcher.RetryMax = time.Hour
+
+	for id := 1; id <= 2; id++ {
+		err := dispatcher.Outbox.Enqueue(Notification{Channel: "mock", Item: MarketItem{ID: id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Flush returns without waiting for the failed notification's retry
+	dispatcher.Flush(context.Background())
+
+	if sent := channel.Sent(); len(sent) != 1 {
+		t.Errorf("Expected 1 notification sent, got %d", len(sent))
+	}
+
+	pending := dispatcher.Outbox.Notifications(NotificationPending)
+	if len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Errorf("Expected 1 pending notification with 1 attempt, got %v", pending)
+	}
+}
+
+func TestDispatcherConcurrencyBound(t *testing.T) {
+	channel := &MockChannel{Delay: 5 * time.Millisecond}
+	dispatcher := testDispatcher(t, channel)
+	dispatcher.Workers = 3
+
+	for i := 0; i < 20; i++ {
+		err := dispatcher.Outbox.Enqueue(Notification{Channel: "mock", Item: MarketItem{ID: i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { return len(channel.Sent()) == 20 })
+
+	cancel()
+	<-done
+
+	if max := atomic.LoadInt32(&channel.maxConcurrent); max > 3 {
+		t.Errorf("Expected at most 3 concurrent sends, got %d", max)
+	}
+
+	// Each notification should be delivered exactly once
+	seen := map[string]bool{}
+	for _, n := range channel.Sent() {
+		if seen[n.ID] {
+			t.Errorf("Notification %s delivered more than once", n.ID)
+		}
+		seen[n.ID] = true
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	dispatcher := NewDispatcher(nil, nil)
+	dispatcher.RetryBase = time.Second
+	dispatcher.RetryMax = 10 * time.Second
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+
+	for i, backoff := range expected {
+		if actual := dispatcher.Backoff(i + 1); actual != backoff {
+			t.Errorf("Expected backoff %s after %d attempts, got %s", backoff, i+1, actual)
+		}
+	}
+}
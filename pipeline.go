@@ -0,0 +1,343 @@
+package notifier
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Pipeline stages, used to label queue depth metrics
+const (
+	stageLists  = "lists"
+	stageStats  = "stats"
+	stageScrape = "scrape"
+)
+
+// itemResult is a list item passing through the pipeline
+type itemResult struct {
+	// index is the position of the item across the watched lists,
+	// results are handled in this order
+	index     int
+	item      ListItem
+	listRules Rules
+
+	marketItem *MarketItem
+	err        error
+	decisions  []Decision
+	notify     bool
+	scraped    bool
+	parse      ParseReport
+}
+
+// parseProblems returns the problems scraping the item's listings
+func (r *itemResult) parseProblems() []string {
+	if !r.scraped {
+		return nil
+	}
+
+	return r.parse.Problems(r.marketItem.NumForSale)
+}
+
+// ScrapeListedItems scrapes the marketplace listings of a release with the
+// client's selectors, stopping at the listings seen previously. Each page
+// waits on the client's rate limiter so scrapes share the api's request
+// budget, and is fetched with the client's timeout
+func (c *Client) ScrapeListedItems(ctx context.Context, id string, seen []string) ([]ListedItem, ParseReport, error) {
+	return ScrapeListedItems(ctx, id, c.Selectors, ScrapeOptions{
+		MaxPages:   c.ScrapeMaxPages,
+		Seen:       seen,
+		Wait:       c.wait,
+		HTTPClient: c.HTTPClient,
+		WebURL:     c.WebURL,
+	})
+}
+
+// workers returns the number of workers to use for a stage
+func workers(configured int) int {
+	if configured < 1 {
+		return 1
+	}
+
+	return configured
+}
+
+// fetchLists fetches the items of each list concurrently (or takes them
+// from the list cache), returning the lists in their original order.
+// Lists not fetched before ctx is done have their Err set to ctx's error
+func (n *Notifier) fetchLists(ctx context.Context, userLists []UserList) []WatchedList {
+	watched := make([]WatchedList, len(userLists))
+	queue := make(chan int, len(userLists))
+
+	for i, list := range userLists {
+		rules, err := ParseListRules(list.Description)
+		if err != nil {
+			log.Warnf("Ignoring rules for list %s due to %v", list.Name, err)
+		}
+
+		watched[i] = WatchedList{List: list, Rules: rules}
+		queue <- i
+	}
+	close(queue)
+	queueDepth.WithLabelValues(stageLists).Set(float64(len(queue)))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers(n.Config.Pipeline.ListWorkers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				queueDepth.WithLabelValues(stageLists).Set(float64(len(queue)))
+
+				list := &watched[i]
+				if err := ctx.Err(); err != nil {
+					list.Err = err
+					continue
+				}
+
+				log.Debugf("Fetching list '%s'", list.List.Name)
+
+				list.Items, list.Err = n.lists.Items(list.List)
+				if list.Err != nil {
+					log.Errorf("Error getting list items for %s due to %v", list.List.Name, list.Err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := n.lists.Prune(userLists); err != nil {
+		log.Errorf("Unable to prune list cache due to %v", err)
+	}
+
+	return watched
+}
+
+// runPipeline fetches the market stats of each item and scrapes the
+// listings of those to notify of, with a pool of workers for each stage.
+// Items not finished before ctx is done are dropped. The results are
+// returned in the order of their index
+func (n *Notifier) runPipeline(ctx context.Context, items []*itemResult) []*itemResult {
+	config := n.Config.Pipeline
+	statsQueue := make(chan *itemResult, 2*workers(config.StatsWorkers))
+	scrapeQueue := make(chan *itemResult, 2*workers(config.ScrapeWorkers))
+	done := make(chan *itemResult, len(items))
+
+	go func() {
+		defer close(statsQueue)
+
+		for _, item := range items {
+			if ctx.Err() != nil {
+				return
+			}
+
+			select {
+			case statsQueue <- item:
+				queueDepth.WithLabelValues(stageStats).Set(float64(len(statsQueue)))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var statsWG sync.WaitGroup
+	for w := 0; w < workers(config.StatsWorkers); w++ {
+		statsWG.Add(1)
+		go func() {
+			defer statsWG.Done()
+
+			for result := range statsQueue {
+				queueDepth.WithLabelValues(stageStats).Set(float64(len(statsQueue)))
+				if ctx.Err() != nil {
+					continue
+				}
+
+				n.fetchStats(result)
+
+				if result.err == nil && result.notify {
+					scrapeQueue <- result
+					queueDepth.WithLabelValues(stageScrape).Set(float64(len(scrapeQueue)))
+				} else {
+					done <- result
+				}
+			}
+		}()
+	}
+
+	go func() {
+		statsWG.Wait()
+		close(scrapeQueue)
+	}()
+
+	var scrapeWG sync.WaitGroup
+	for w := 0; w < workers(config.ScrapeWorkers); w++ {
+		scrapeWG.Add(1)
+		go func() {
+			defer scrapeWG.Done()
+
+			for result := range scrapeQueue {
+				queueDepth.WithLabelValues(stageScrape).Set(float64(len(scrapeQueue)))
+				if ctx.Err() != nil {
+					continue
+				}
+
+				n.scrapeListings(ctx, result)
+				done <- result
+			}
+		}()
+	}
+
+	scrapeWG.Wait()
+	close(done)
+
+	results := []*itemResult{}
+	for result := range done {
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].index < results[j].index
+	})
+
+	return results
+}
+
+// fetchStats gets the marketplace statistics of an item and evaluates them
+func (n *Notifier) fetchStats(result *itemResult) {
+	result.marketItem, result.err = n.MarketItem(result.item, result.listRules)
+	if result.err != nil {
+		return
+	}
+
+	n.evaluateItem(result)
+}
+
+// evaluateItem compares the statistics of an item with its previous stats.
+// Only notify if a previous item exists in the map (don't notify on first run)
+func (n *Notifier) evaluateItem(result *itemResult) {
+	marketItem := result.marketItem
+
+	// Previous items are only written once the pipeline has finished
+	var previous *MarketItem
+	if previousMarketItem, ok := n.previousMarketItems[marketItem.ID]; ok {
+		previous = &previousMarketItem
+	}
+
+	decision := Decision{
+		Time:      time.Now(),
+		ReleaseID: marketItem.ID,
+		Name:      marketItem.Name,
+		Rules:     EvaluateItem(*marketItem, previous),
+	}
+	decision.Notify = Passed(decision.Rules)
+
+	result.decisions = []Decision{decision}
+	result.notify = decision.Notify
+
+	// Recorded after evaluating so the median is of earlier prices
+	n.history.Record(marketItem.ID, marketItem.LowestPrice, time.Now())
+
+	if result.notify {
+		marketItem.PreviousLowestPrice = previous.LowestPrice
+	}
+
+	// Remembered until the listings are next scraped
+	if previous != nil {
+		marketItem.SeenListings = previous.SeenListings
+	}
+}
+
+// scrapeListings scrapes the listings of an item, keeping those which
+// satisfy its rules
+func (n *Notifier) scrapeListings(ctx context.Context, result *itemResult) {
+	marketItem := result.marketItem
+
+	// Scraping is best effort, without listings the price is notified
+	listings, parse, err := n.Client.ScrapeListedItems(ctx, strconv.Itoa(marketItem.ID), marketItem.SeenListings)
+	if err != nil {
+		log.Warnf("Unable to scrape listings for %s due to %v", marketItem.Name, err)
+	} else {
+		result.scraped = true
+		result.parse = parse
+		n.Config.Imports.EstimateLandedCosts(listings)
+		n.priceSuggestions(result.item, marketItem, listings)
+
+		marketItem.SeenListings = []string{}
+		for _, listing := range listings {
+			marketItem.SeenListings = append(marketItem.SeenListings, listing.ID)
+		}
+	}
+
+	marketItem.Listings = []ListedItem{}
+	for _, listing := range listings {
+		decision := Decision{
+			Time:      time.Now(),
+			ReleaseID: marketItem.ID,
+			Name:      marketItem.Name,
+			ListingID: listing.ID,
+			Rules:     EvaluateListing(listing, *marketItem),
+		}
+		decision.Notify = Passed(decision.Rules)
+		result.decisions = append(result.decisions, decision)
+
+		if decision.Notify {
+			marketItem.Listings = append(marketItem.Listings, listing)
+		}
+	}
+
+	// Without a matching listing the rules only listings can be checked
+	// against aren't satisfied, so the price isn't notified instead
+	listingsRule := ListingsRule(*marketItem, len(listings), result.scraped)
+	result.notify = listingsRule.Passed
+	result.decisions = append(result.decisions, Decision{
+		Time:      time.Now(),
+		ReleaseID: marketItem.ID,
+		Name:      marketItem.Name,
+		Notify:    listingsRule.Passed,
+		Rules:     []RuleResult{listingsRule},
+	})
+}
+
+// finishItem records the outcome of an item that has been through the
+// pipeline, notifying of it if it satisfied our notify conditions
+func (n *Notifier) finishItem(result *itemResult, report *CycleReport) {
+	if result.err != nil {
+		n.itemFailed(result.item, result.err, report)
+		return
+	}
+
+	marketItem := result.marketItem
+
+	if err := n.quarantine.RecordSuccess(marketItem.ID); err != nil {
+		log.Errorf("Unable to clear failures of %s due to %v", marketItem.Name, err)
+	}
+
+	if result.notify {
+		itemsEvaluated.WithLabelValues("notify").Inc()
+	} else {
+		itemsEvaluated.WithLabelValues("skip").Inc()
+	}
+
+	if n.Config.Notify.DryRun {
+		decisions := result.decisions
+		if result.notify {
+			decisions = append(decisions, n.dryRunNotify(*marketItem)...)
+		}
+
+		if err := n.decisions.Write(decisions...); err != nil {
+			log.Errorf("Unable to write decisions for %s due to %v", marketItem.Name, err)
+		}
+	} else if result.notify {
+		Notify(*marketItem, n.Channels, n.deduper, n.outbox)
+	}
+
+	// Add new marketplace statistics regardless of previous logic outcome
+	n.previousMarketItems[marketItem.ID] = *marketItem
+
+	log.Debugf("Updated market item %v", *marketItem)
+}
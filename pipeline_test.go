@@ -0,0 +1,181 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunPipeline(t *testing.T) {
+	n := testNotifier(t)
+	n.Config.Pipeline.StatsWorkers = 4
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	// Earlier items respond slowest so they finish last
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/marketplace/stats/"))
+		time.Sleep(time.Duration(10-id) * 5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		MockJsonHandler(t, MarketResponse{NumForSale: id}).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	n.Config.Discogs.APIURL = ts.URL
+
+	items := []*itemResult{}
+	for i := 0; i < 8; i++ {
+		items = append(items, &itemResult{index: i, item: ListItem{ID: i + 1, Type: "master"}})
+	}
+
+	results := n.runPipeline(context.Background(), items)
+	if len(results) != len(items) {
+		t.Fatalf("Expected %d results, got %d", len(items), len(results))
+	}
+
+	for i, result := range results {
+		if result.err != nil {
+			t.Fatal(result.err)
+		}
+
+		if result.index != i || result.marketItem.NumForSale != i+1 {
+			t.Errorf("Expected result %d in order, got %d", i, result.index)
+		}
+	}
+
+	if maxInFlight < 2 {
+		t.Errorf("Expected stats to be fetched concurrently, got %d at once", maxInFlight)
+	}
+}
+
+func TestRunPipelineCancelled(t *testing.T) {
+	n := testNotifier(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	items := []*itemResult{&itemResult{item: ListItem{ID: 10, Type: "master"}}}
+	if results := n.runPipeline(ctx, items); len(results) != 0 {
+		t.Errorf("Expected no results once cancelled, got %v", results)
+	}
+}
+
+func TestRunPipelineListingRules(t *testing.T) {
+	n := testNotifier(t)
+	n.Channels = []Channel{&MockChannel{}}
+
+	// Every listing ships from the UK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sell/release/10" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, sellPageHTML(false, "2", "1"))
+	}))
+	defer ts.Close()
+	n.Client.WebURL = ts.URL
+
+	tests := []struct {
+		name   string
+		id     int
+		reason string
+	}{
+		{"every listing rejected", 10, "no listing satisfies the listing rules"},
+		{"scrape failed", 11, "listings couldn't be scraped to check the listing rules"},
+	}
+
+	for _, test := range tests {
+		n.previousMarketItems[test.id] = MarketItem{ID: test.id, NumForSale: 1, LowestPrice: 30}
+
+		items := []*itemResult{&itemResult{item: ListItem{ID: test.id, Comment: "ships=US", Type: "master"}}}
+		results := n.runPipeline(context.Background(), items)
+		if len(results) != 1 || results[0].err != nil {
+			t.Fatalf("%s: Expected 1 result, got %v", test.name, results)
+		}
+
+		result := results[0]
+		n.finishItem(result, &CycleReport{})
+
+		last := result.decisions[len(result.decisions)-1]
+		if result.notify || last.Notify || last.Rules[0].Reason != test.reason {
+			t.Errorf("%s: Expected not to notify because %s, got %v", test.name, test.reason, last)
+		}
+	}
+
+	if pending := n.outbox.Notifications(NotificationPending); len(pending) != 0 {
+		t.Errorf("Expected nothing queued, got %v", pending)
+	}
+}
+
+func TestRunPipelinePriceSuggestions(t *testing.T) {
+	n := testNotifier(t)
+	n.Channels = []Channel{&MockChannel{}}
+
+	var mu sync.Mutex
+	requested := []string{}
+
+	mux := http.NewServeMux()
+	mux.Handle("/marketplace/stats/", MockJsonHandler(t, MarketResponse{
+		LowestPrice: LowestPrice{Currency: "AUD", Value: 25},
+		NumForSale:  1,
+	}))
+	mux.HandleFunc("/marketplace/price_suggestions/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		MockJsonHandler(t, map[string]LowestPrice{"Mint (M)": {Currency: "AUD", Value: 25}}).ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/sell/release/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sellPageHTML(false, "1"))
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	n.Config.Discogs.APIURL = ts.URL
+	n.Client.WebURL = ts.URL
+
+	// Nothing new is for sale for item 20 so it isn't scraped, item 21 has
+	// a new listing
+	n.previousMarketItems[20] = MarketItem{ID: 20, NumForSale: 1, LowestPrice: 25}
+	n.previousMarketItems[21] = MarketItem{ID: 21, NumForSale: 0}
+
+	items := []*itemResult{
+		&itemResult{index: 0, item: ListItem{ID: 20, Comment: "suggested=90%", Type: "release"}},
+		&itemResult{index: 1, item: ListItem{ID: 21, Comment: "suggested=90%", Type: "release"}},
+	}
+
+	results := n.runPipeline(context.Background(), items)
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v", results)
+	}
+
+	expected := []string{"/marketplace/price_suggestions/21"}
+	if !cmp.Equal(requested, expected) {
+		t.Errorf("Expected suggestions requested for %v, got %v", expected, requested)
+	}
+
+	// The listing at A$20 is under 90% of the A$25 suggestion
+	if listings := results[1].marketItem.Listings; !results[1].notify || len(listings) != 1 {
+		t.Errorf("Expected the listing under the suggested price to notify, got %v", listings)
+	}
+}
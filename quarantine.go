@@ -0,0 +1,147 @@
+package notifier
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ItemFailure counts the consecutive failures of an item
+type ItemFailure struct {
+	ID               int       `json:"id"`
+	Name             string    `json:"name"`
+	Kind             ErrorKind `json:"kind"`
+	Count            int       `json:"count"`
+	LastError        string    `json:"last_error"`
+	LastFailure      time.Time `json:"last_failure"`
+	QuarantinedUntil time.Time `json:"quarantined_until,omitempty"`
+}
+
+// Quarantined returns whether the item is quarantined at now
+func (f ItemFailure) Quarantined(now time.Time) bool {
+	return now.Before(f.QuarantinedUntil)
+}
+
+// Quarantine counts item failures, skipping items which persistently fail
+// so they don't waste requests every cycle. Items are quarantined after
+// a number of consecutive failures, or straight away if the failure is
+// permanent (not found or blocked), and are retried once their quarantine
+// ends. Transient failures such as rate limiting aren't counted
+type Quarantine struct {
+	mu       sync.Mutex
+	path     string
+	after    int
+	duration time.Duration
+	items    map[int]*ItemFailure
+}
+
+// NewQuarantine creates a Quarantine persisted to path which quarantines
+// items for duration after failing the given number of times in a row
+func NewQuarantine(path string, after int, duration time.Duration) (*Quarantine, error) {
+	q := &Quarantine{
+		path:     path,
+		after:    after,
+		duration: duration,
+		items:    map[int]*ItemFailure{},
+	}
+
+	if err := readJSONFile(path, &q.items); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Skip returns whether the item is quarantined at now
+func (q *Quarantine) Skip(id int, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	failure, ok := q.items[id]
+
+	return ok && failure.Quarantined(now)
+}
+
+// RecordFailure counts a failure of an item and returns whether it has
+// been newly quarantined. Transient failures aren't the item's fault so
+// aren't counted, otherwise an outage would quarantine every item
+func (q *Quarantine) RecordFailure(id int, name string, err error, now time.Time) (bool, error) {
+	if IsTransient(err) {
+		return false, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	failure, ok := q.items[id]
+	if !ok {
+		failure = &ItemFailure{ID: id}
+		q.items[id] = failure
+	}
+
+	failure.Name = name
+	failure.Kind = ErrorKindOf(err)
+	failure.Count++
+	failure.LastError = err.Error()
+	failure.LastFailure = now
+
+	quarantined := false
+	if q.duration > 0 && (failure.Count >= q.after || IsPermanent(err)) {
+		// Items retried after their quarantine ends are only quarantined
+		// again rather than reported as newly broken
+		quarantined = failure.QuarantinedUntil.IsZero()
+		failure.QuarantinedUntil = now.Add(q.duration)
+	}
+
+	return quarantined, writeJSONFile(q.path, q.items)
+}
+
+// RecordSuccess clears the failures of an item
+func (q *Quarantine) RecordSuccess(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.items[id]; !ok {
+		return nil
+	}
+
+	delete(q.items, id)
+
+	return writeJSONFile(q.path, q.items)
+}
+
+// Quarantined returns the items quarantined at now ordered by ID
+func (q *Quarantine) Quarantined(now time.Time) []ItemFailure {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	failures := []ItemFailure{}
+	for _, failure := range q.items {
+		if failure.Quarantined(now) {
+			failures = append(failures, *failure)
+		}
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].ID < failures[j].ID
+	})
+
+	return failures
+}
+
+// Summary describes the items quarantined at now for admins
+func (q *Quarantine) Summary(now time.Time) string {
+	failures := q.Quarantined(now)
+
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "%d item(s) are failing and are being skipped:\n", len(failures))
+
+	for _, f := range failures {
+		fmt.Fprintf(&summary, "\n- %s (%d): %s, failed %d time(s), skipped until %s\n  %s\n",
+			f.Name, f.ID, f.Kind, f.Count, f.QuarantinedUntil.Format(time.RFC1123), f.LastError)
+	}
+
+	return summary.String()
+}
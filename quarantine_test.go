@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures.json")
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	q, err := NewQuarantine(path, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := &APIError{Kind: ErrorDecode, URL: "https://api.discogs.com/marketplace/stats/1"}
+
+	if quarantined, err := q.RecordFailure(1, "Item 1", failure, now); err != nil || quarantined {
+		t.Errorf("Expected first failure not to quarantine, got %t %v", quarantined, err)
+	}
+
+	if quarantined, _ := q.RecordFailure(1, "Item 1", failure, now); !quarantined {
+		t.Error("Expected second failure to quarantine")
+	}
+
+	// Permanent failures quarantine straight away
+	notFound := &APIError{Kind: ErrorNotFound, URL: "https://api.discogs.com/marketplace/stats/2"}
+	if quarantined, _ := q.RecordFailure(2, "Item 2", notFound, now); !quarantined {
+		t.Error("Expected not found to quarantine straight away")
+	}
+
+	if !q.Skip(1, now.Add(time.Minute)) || q.Skip(1, now.Add(2*time.Hour)) {
+		t.Error("Expected item to be skipped only during quarantine")
+	}
+
+	// Failing again after quarantine isn't newly quarantined
+	if quarantined, _ := q.RecordFailure(1, "Item 1", failure, now.Add(2*time.Hour)); quarantined {
+		t.Error("Expected retried item not to be reported as newly quarantined")
+	}
+
+	summary := q.Summary(now.Add(time.Minute))
+	for _, expected := range []string{"2 item(s)", "Item 1 (1): decode, failed 3 time(s)", "Item 2 (2): not_found"} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("Expected summary to contain '%s', got:\n%s", expected, summary)
+		}
+	}
+
+	if err := q.RecordSuccess(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload to check failures were persisted
+	q, err = NewQuarantine(path, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quarantined := q.Quarantined(now.Add(time.Minute))
+	if len(quarantined) != 1 || quarantined[0].ID != 1 || quarantined[0].Count != 3 {
+		t.Errorf("Expected only item 1 quarantined after reload, got %v", quarantined)
+	}
+}
+
+func TestQuarantineTransientFailures(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	q, err := NewQuarantine(filepath.Join(t.TempDir(), "failures.json"), 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited := &APIError{Kind: ErrorRateLimited, StatusCode: http.StatusTooManyRequests}
+	for i := 0; i < 10; i++ {
+		if quarantined, err := q.RecordFailure(1, "Item 1", limited, now); err != nil || quarantined {
+			t.Fatalf("Expected rate limited failure not to quarantine, got %t %v", quarantined, err)
+		}
+	}
+
+	if q.Skip(1, now) || len(q.Quarantined(now)) != 0 {
+		t.Error("Expected rate limited item not to be quarantined")
+	}
+}
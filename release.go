@@ -0,0 +1,170 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ArtistNames returns the release artists joined as displayed on discogs
+// e.g. "Artist A & Artist B"
+func (r Release) ArtistNames() string {
+	var names strings.Builder
+
+	for i, artist := range r.Artists {
+		names.WriteString(artist.Name)
+
+		if i < len(r.Artists)-1 {
+			join := strings.TrimSpace(artist.Join)
+			if join == "" || join == "," {
+				names.WriteString(", ")
+			} else {
+				names.WriteString(" " + join + " ")
+			}
+		}
+	}
+
+	return names.String()
+}
+
+// LabelName returns the name of the first label of the release
+func (r Release) LabelName() string {
+	if len(r.Labels) == 0 {
+		return ""
+	}
+
+	return r.Labels[0].Name
+}
+
+// CatalogNumber returns the catalogue number of the first label of the release
+func (r Release) CatalogNumber() string {
+	if len(r.Labels) == 0 {
+		return ""
+	}
+
+	return r.Labels[0].CatalogNumber
+}
+
+// HasFormat returns whether any format of the release has the name,
+// description or text, ignoring case
+func (r Release) HasFormat(name string) bool {
+	for _, format := range r.Formats {
+		if strings.EqualFold(format.Name, name) || strings.EqualFold(format.Text, name) || containsFold(format.Descriptions, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasGenre returns whether the release has the genre or style, ignoring
+// case
+func (r Release) HasGenre(name string) bool {
+	return containsFold(r.Genres, name) || containsFold(r.Styles, name)
+}
+
+// containsFold returns whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FormatDescription returns the formats of the release e.g. "Vinyl, LP, Album"
+func (r Release) FormatDescription() string {
+	parts := []string{}
+
+	for _, format := range r.Formats {
+		parts = append(parts, format.Name)
+		parts = append(parts, format.Descriptions...)
+		if format.Text != "" {
+			parts = append(parts, format.Text)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// GetRelease takes a release ID and url prefix and returns the release
+// metadata
+func (c *Client) GetRelease(id int, urlPrefix string) (*Release, error) {
+	var data Release
+
+	err := c.getJSON(fmt.Sprintf("%s%d", urlPrefix, id), &data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+type cachedRelease struct {
+	Release   Release   `json:"release"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// ReleaseCache caches release metadata on disk so it is fetched once
+// per TTL rather than every cycle
+type ReleaseCache struct {
+	mu       sync.Mutex
+	path     string
+	ttl      time.Duration
+	releases map[int]cachedRelease
+	fetch    func(id int) (*Release, error)
+}
+
+// NewReleaseCache creates a ReleaseCache persisted to path which uses
+// fetch to retrieve releases that are missing or older than ttl
+func NewReleaseCache(path string, ttl time.Duration, fetch func(id int) (*Release, error)) (*ReleaseCache, error) {
+	c := &ReleaseCache{
+		path:     path,
+		ttl:      ttl,
+		releases: map[int]cachedRelease{},
+		fetch:    fetch,
+	}
+
+	if err := readJSONFile(path, &c.releases); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get returns the release with the given ID from the cache, fetching it
+// if it is missing or expired. If fetching fails an expired release is
+// returned rather than nothing
+func (c *ReleaseCache) Get(id int) (*Release, error) {
+	c.mu.Lock()
+	cached, ok := c.releases[id]
+	c.mu.Unlock()
+
+	if ok && time.Since(cached.FetchedAt) < c.ttl {
+		return &cached.Release, nil
+	}
+
+	release, err := c.fetch(id)
+	if err != nil {
+		if ok {
+			log.Warnf("Unable to refresh release %d, using cached release due to %v", id, err)
+			return &cached.Release, nil
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.releases[id] = cachedRelease{
+		Release:   *release,
+		FetchedAt: time.Now(),
+	}
+
+	return release, writeJSONFile(c.path, c.releases)
+}
@@ -0,0 +1,162 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testRelease() Release {
+	return Release{
+		ID:    42,
+		Title: "Selected Ambient Works 85-92",
+		Artists: []Artist{
+			Artist{Name: "Aphex Twin", Join: "&"},
+			Artist{Name: "Guest"},
+		},
+		Year: 1992,
+		Labels: []Label{
+			Label{Name: "Apollo", CatalogNumber: "AMB 3922"},
+		},
+		Formats: []Format{
+			Format{Name: "Vinyl", Quantity: "2", Descriptions: []string{"LP", "Album"}},
+		},
+		Genres:  []string{"Electronic"},
+		Styles:  []string{"Ambient", "IDM"},
+		Country: "Belgium",
+		Thumb:   "https://img.discogs.com/thumb.jpg",
+	}
+}
+
+func TestGetRelease(t *testing.T) {
+	responseData := testRelease()
+
+	mux := http.NewServeMux()
+	mux.Handle(fmt.Sprintf("/%d", responseData.ID), MockJsonHandler(t, responseData))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	release, err := testClient().GetRelease(responseData.ID, ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(*release, responseData) {
+		t.Errorf("Expected release %v, got %v", responseData, *release)
+	}
+}
+
+func TestGetPriceSuggestions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/42", MockJsonHandler(t, map[string]LowestPrice{
+		"Near Mint (NM or M-)":  {Currency: "AUD", Value: 40},
+		"Very Good Plus (VG+)":  {Currency: "AUD", Value: 30.5},
+		"Slightly Scuffed (SS)": {Currency: "AUD", Value: 1},
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	suggestions, err := testClient().GetPriceSuggestions(42, ts.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[Grading]LowestPrice{
+		GradeNearMint:     {Currency: "AUD", Value: 40},
+		GradeVeryGoodPlus: {Currency: "AUD", Value: 30.5},
+	}
+	if !cmp.Equal(suggestions, expected) {
+		t.Errorf("Expected suggestions %v, got %v", expected, suggestions)
+	}
+}
+
+func TestReleaseDescriptions(t *testing.T) {
+	release := testRelease()
+
+	if artists := release.ArtistNames(); artists != "Aphex Twin & Guest" {
+		t.Errorf("Expected artists 'Aphex Twin & Guest', got '%s'", artists)
+	}
+	if formats := release.FormatDescription(); formats != "Vinyl, LP, Album" {
+		t.Errorf("Expected formats 'Vinyl, LP, Album', got '%s'", formats)
+	}
+	if label := release.LabelName() + " " + release.CatalogNumber(); label != "Apollo AMB 3922" {
+		t.Errorf("Expected label 'Apollo AMB 3922', got '%s'", label)
+	}
+
+	n := testNotification()
+	n.Item.Release = &release
+
+	text, err := NewTemplates("").Render("email", EventNewListing, FormatText, NewTemplateData(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Aphex Twin & Guest - Selected Ambient Works 85-92 (1992)") {
+		t.Errorf("Expected release metadata in email text, got:\n%s", text)
+	}
+}
+
+func TestReleaseCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "releases.json")
+	fetches := 0
+	fail := false
+
+	fetch := func(id int) (*Release, error) {
+		fetches++
+		if fail {
+			return nil, errors.New("fetch failed")
+		}
+		release := testRelease()
+		release.ID = id
+		return &release, nil
+	}
+
+	cache, err := NewReleaseCache(path, time.Hour, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get(42); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected 1 fetch for repeated gets, got %d", fetches)
+	}
+
+	// Reload from disk, the cached release should still be used
+	cache, err = NewReleaseCache(path, time.Hour, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release, err := cache.Get(42); err != nil || release.Title != testRelease().Title {
+		t.Errorf("Expected cached release after reload, got %v %v", release, err)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected no fetch after reload, got %d fetches", fetches)
+	}
+
+	// Expired releases are refetched, falling back to the stale copy on failure
+	cache.ttl = 0
+	fail = true
+
+	if release, err := cache.Get(42); err != nil || release.ID != 42 {
+		t.Errorf("Expected stale release when refresh fails, got %v %v", release, err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected expired release to be refetched, got %d fetches", fetches)
+	}
+
+	if _, err := cache.Get(7); err == nil {
+		t.Error("Expected error for uncached release when fetch fails")
+	}
+}
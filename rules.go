@@ -0,0 +1,584 @@
+package notifier
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Rules are the notification conditions for an item. They are parsed from
+// the description of a list (applying to every item in it) and from item
+// comments (applying to that item only).
+//
+// Rules are written as whitespace separated 'key=value' pairs, values
+// containing spaces can be quoted. A bare number is a maximum price
+// e.g. '30.50' or 'max=30.50'. Minimum conditions are written with '>='
+// e.g. 'media>=VG+ sleeve>=VG'. Where listings ship from is limited with
+// comma separated countries and regions e.g. 'ships=EU,UK' or '!ships=US'.
+// A maximum landed cost, including shipping and import charges, is
+// written 'landed=45'. Maximum prices by media condition are written
+// 'max.NM=45 max.VG+=30', optionally adjusted by sleeve condition e.g.
+// 'sleeve.VG=-5'. Thresholds relative to the market are percentages e.g.
+// 'median=80%' (of the median lowest price), 'drop=10%' (below the
+// previous lowest price) or 'suggested=90%' (of discogs' suggested price
+// for a listing's condition). Listing comments are filtered by comma
+// separated keywords with 'has=sealed,promo' (any of) and
+// '!has=warped,skips' (none of), or by regular expressions with 'match='
+// and '!match='. Comment rules ignore case. Release metadata is checked
+// with comma separated formats and genres or styles (any of) e.g.
+// 'format=LP,12"' or 'genre=Jazz,Dub', and a range of years e.g.
+// 'year>=1970 year<=1979'
+type Rules struct {
+	MaxPrice  float64
+	MaxLanded float64
+	MinMedia  Grading
+	MinSleeve Grading
+	// ShipsFrom are the countries and regions listings may ship from,
+	// NotShipsFrom those they may not. Both are in their canonical form
+	ShipsFrom    []string
+	NotShipsFrom []string
+	// MaxByMedia are maximum prices for media at or better than each grade,
+	// SleeveAdjustments are added to them for sleeves at or worse than
+	// each grade. The closest grade is used
+	MaxByMedia        map[Grading]float64
+	SleeveAdjustments map[Grading]float64
+	MedianPercent     float64
+	DropPercent       float64
+	SuggestedPercent  float64
+	Keywords          []string
+	NotKeywords       []string
+	Match             string
+	NotMatch          string
+	Formats           []string
+	Genres            []string
+	MinYear           int
+	MaxYear           int
+}
+
+// ruleList splits a comma separated rule value, ignoring empty values
+func ruleList(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// parsePercent parses a percentage with or without a '%' suffix
+func parsePercent(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent <= 0 {
+		return 0, fmt.Errorf("invalid percentage '%s'", value)
+	}
+
+	return percent, nil
+}
+
+// ruleTokens splits rules text on whitespace, keeping quoted values together.
+// Quotes only open at the start of a token or value so apostrophes in
+// free text are kept as is
+func ruleTokens(text string) ([]string, error) {
+	tokens := []string{}
+	var token strings.Builder
+	var quote, prev rune
+	inToken := false
+
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				token.WriteRune(r)
+			}
+		case (r == '"' || r == '\'') && (!inToken || prev == '='):
+			quote = r
+			inToken = true
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+		prev = r
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in '%s'", text)
+	}
+
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+// applyConditionRule sets a condition tiered price rule e.g. 'max.VG+=30'
+// or 'sleeve.G=-10'
+func (r *Rules) applyConditionRule(key, value string) error {
+	i := strings.Index(key, ".")
+	kind, condition := strings.ToLower(key[:i]), key[i+1:]
+
+	grading, err := ParseGrading(condition)
+	if err != nil {
+		return fmt.Errorf("invalid condition '%s' in rule '%s'", condition, key)
+	}
+
+	amount, err := strconv.ParseFloat(value, 64)
+
+	switch kind {
+	case "max":
+		if !grading.IsGrade() {
+			return fmt.Errorf("invalid condition '%s' in rule '%s'", condition, key)
+		}
+		if err != nil || amount < 0 {
+			return fmt.Errorf("invalid max price '%s' for %s", value, key)
+		}
+		if r.MaxByMedia == nil {
+			r.MaxByMedia = map[Grading]float64{}
+		}
+		r.MaxByMedia[grading] = amount
+	case "sleeve":
+		// Sleeves can be generic or missing but not ungraded
+		if grading == GradeNotGraded {
+			return fmt.Errorf("invalid condition '%s' in rule '%s'", condition, key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid sleeve adjustment '%s' for %s", value, key)
+		}
+		if r.SleeveAdjustments == nil {
+			r.SleeveAdjustments = map[Grading]float64{}
+		}
+		r.SleeveAdjustments[grading] = amount
+	default:
+		return fmt.Errorf("unknown rule '%s'", key)
+	}
+
+	return nil
+}
+
+// applyRule sets the rule for a key and value
+func (r *Rules) applyRule(key, value string) error {
+	if strings.Contains(key, ".") {
+		return r.applyConditionRule(key, value)
+	}
+
+	switch strings.ToLower(key) {
+	case "max":
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price < 0 {
+			return fmt.Errorf("invalid max price '%s'", value)
+		}
+		r.MaxPrice = price
+	case "landed":
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price < 0 {
+			return fmt.Errorf("invalid max landed cost '%s'", value)
+		}
+		r.MaxLanded = price
+	case "media>", "sleeve>":
+		grading, err := ParseGrading(value)
+		if err != nil || !grading.IsGrade() {
+			return fmt.Errorf("invalid minimum condition '%s'", value)
+		}
+		if strings.EqualFold(key, "media>") {
+			r.MinMedia = grading
+		} else {
+			r.MinSleeve = grading
+		}
+	case "median", "suggested":
+		percent, err := parsePercent(value)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(key, "median") {
+			r.MedianPercent = percent
+		} else {
+			r.SuggestedPercent = percent
+		}
+	case "drop":
+		percent, err := parsePercent(value)
+		if err != nil || percent >= 100 {
+			return fmt.Errorf("invalid price drop '%s'", value)
+		}
+		r.DropPercent = percent
+	case "format", "genre":
+		values := ruleList(value)
+		if len(values) == 0 {
+			return fmt.Errorf("no values in rule '%s'", key)
+		}
+		if strings.EqualFold(key, "format") {
+			r.Formats = values
+		} else {
+			r.Genres = values
+		}
+	case "year>", "year<":
+		year, err := strconv.Atoi(value)
+		if err != nil || year <= 0 {
+			return fmt.Errorf("invalid year '%s'", value)
+		}
+		if strings.EqualFold(key, "year>") {
+			r.MinYear = year
+		} else {
+			r.MaxYear = year
+		}
+	case "has", "!has":
+		keywords := ruleList(value)
+		if len(keywords) == 0 {
+			return fmt.Errorf("no keywords in rule '%s'", key)
+		}
+		if strings.EqualFold(key, "has") {
+			r.Keywords = keywords
+		} else {
+			r.NotKeywords = keywords
+		}
+	case "match", "!match":
+		if _, err := compileCommentPattern(value); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %v", value, err)
+		}
+		if strings.EqualFold(key, "match") {
+			r.Match = value
+		} else {
+			r.NotMatch = value
+		}
+	case "ships", "!ships":
+		locations := []string{}
+		for _, location := range strings.Split(value, ",") {
+			location, err := ParseLocation(location)
+			if err != nil {
+				return err
+			}
+			locations = append(locations, location)
+		}
+		if strings.EqualFold(key, "ships") {
+			r.ShipsFrom = locations
+		} else {
+			r.NotShipsFrom = locations
+		}
+	default:
+		return fmt.Errorf("unknown rule '%s'", key)
+	}
+
+	return nil
+}
+
+// ParseRules parses item comment rules. Any text which isn't a rule is
+// an error
+func ParseRules(text string) (Rules, error) {
+	rules := Rules{}
+
+	tokens, err := ruleTokens(text)
+	if err != nil {
+		return rules, err
+	}
+
+	for _, token := range tokens {
+		key, value := "max", token
+		if i := strings.Index(token, "="); i >= 0 {
+			key, value = token[:i], token[i+1:]
+		}
+
+		if err := rules.applyRule(key, value); err != nil {
+			return rules, err
+		}
+	}
+
+	return rules, nil
+}
+
+// ParseListRules parses rules from a list description. Descriptions are
+// mostly free text so only 'key=value' tokens are read, anything else
+// (including the notify tag) is ignored
+func ParseListRules(description string) (Rules, error) {
+	rules := Rules{}
+
+	tokens, err := ruleTokens(description)
+	if err != nil {
+		return rules, err
+	}
+
+	for _, token := range tokens {
+		i := strings.Index(token, "=")
+		if i <= 0 {
+			continue
+		}
+
+		if err := rules.applyRule(token[:i], token[i+1:]); err != nil {
+			return rules, err
+		}
+	}
+
+	return rules, nil
+}
+
+// Merge returns the rules with any rules set in override replacing them.
+// Used to apply item rules over the rules of their list
+func (r Rules) Merge(override Rules) Rules {
+	if override.MaxPrice > 0 {
+		r.MaxPrice = override.MaxPrice
+	}
+	if override.MaxLanded > 0 {
+		r.MaxLanded = override.MaxLanded
+	}
+	if override.MinMedia != GradeNotGraded {
+		r.MinMedia = override.MinMedia
+	}
+	if override.MinSleeve != GradeNotGraded {
+		r.MinSleeve = override.MinSleeve
+	}
+	if len(override.ShipsFrom) > 0 {
+		r.ShipsFrom = override.ShipsFrom
+	}
+	if len(override.NotShipsFrom) > 0 {
+		r.NotShipsFrom = override.NotShipsFrom
+	}
+	// Tiers are replaced as a whole, mixing them would be confusing
+	if len(override.MaxByMedia) > 0 {
+		r.MaxByMedia = override.MaxByMedia
+	}
+	if len(override.SleeveAdjustments) > 0 {
+		r.SleeveAdjustments = override.SleeveAdjustments
+	}
+	if override.MedianPercent > 0 {
+		r.MedianPercent = override.MedianPercent
+	}
+	if override.DropPercent > 0 {
+		r.DropPercent = override.DropPercent
+	}
+	if override.SuggestedPercent > 0 {
+		r.SuggestedPercent = override.SuggestedPercent
+	}
+	if len(override.Keywords) > 0 {
+		r.Keywords = override.Keywords
+	}
+	if len(override.NotKeywords) > 0 {
+		r.NotKeywords = override.NotKeywords
+	}
+	if override.Match != "" {
+		r.Match = override.Match
+	}
+	if override.NotMatch != "" {
+		r.NotMatch = override.NotMatch
+	}
+	if len(override.Formats) > 0 {
+		r.Formats = override.Formats
+	}
+	if len(override.Genres) > 0 {
+		r.Genres = override.Genres
+	}
+	if override.MinYear > 0 {
+		r.MinYear = override.MinYear
+	}
+	if override.MaxYear > 0 {
+		r.MaxYear = override.MaxYear
+	}
+
+	return r
+}
+
+// String formats the rules in the syntax they are parsed from
+func (r Rules) String() string {
+	parts := []string{}
+
+	if r.MaxPrice > 0 {
+		parts = append(parts, "max="+strconv.FormatFloat(r.MaxPrice, 'f', -1, 64))
+	}
+	if r.MaxLanded > 0 {
+		parts = append(parts, "landed="+strconv.FormatFloat(r.MaxLanded, 'f', -1, 64))
+	}
+	if r.MinMedia != GradeNotGraded {
+		parts = append(parts, "media>="+r.MinMedia.Abbreviation())
+	}
+	if r.MinSleeve != GradeNotGraded {
+		parts = append(parts, "sleeve>="+r.MinSleeve.Abbreviation())
+	}
+	if len(r.ShipsFrom) > 0 {
+		parts = append(parts, "ships="+strings.Join(r.ShipsFrom, ","))
+	}
+	if len(r.NotShipsFrom) > 0 {
+		parts = append(parts, "!ships="+strings.Join(r.NotShipsFrom, ","))
+	}
+	for _, grading := range sortedGradings(r.MaxByMedia) {
+		parts = append(parts, "max."+grading.Abbreviation()+"="+strconv.FormatFloat(r.MaxByMedia[grading], 'f', -1, 64))
+	}
+	for _, grading := range sortedGradings(r.SleeveAdjustments) {
+		parts = append(parts, "sleeve."+grading.Abbreviation()+"="+strconv.FormatFloat(r.SleeveAdjustments[grading], 'f', -1, 64))
+	}
+	if r.MedianPercent > 0 {
+		parts = append(parts, "median="+strconv.FormatFloat(r.MedianPercent, 'f', -1, 64)+"%")
+	}
+	if r.DropPercent > 0 {
+		parts = append(parts, "drop="+strconv.FormatFloat(r.DropPercent, 'f', -1, 64)+"%")
+	}
+	if r.SuggestedPercent > 0 {
+		parts = append(parts, "suggested="+strconv.FormatFloat(r.SuggestedPercent, 'f', -1, 64)+"%")
+	}
+	if len(r.Keywords) > 0 {
+		parts = append(parts, "has="+quoteRuleValue(strings.Join(r.Keywords, ",")))
+	}
+	if len(r.NotKeywords) > 0 {
+		parts = append(parts, "!has="+quoteRuleValue(strings.Join(r.NotKeywords, ",")))
+	}
+	if r.Match != "" {
+		parts = append(parts, "match="+quoteRuleValue(r.Match))
+	}
+	if r.NotMatch != "" {
+		parts = append(parts, "!match="+quoteRuleValue(r.NotMatch))
+	}
+	if len(r.Formats) > 0 {
+		parts = append(parts, "format="+quoteRuleValue(strings.Join(r.Formats, ",")))
+	}
+	if len(r.Genres) > 0 {
+		parts = append(parts, "genre="+quoteRuleValue(strings.Join(r.Genres, ",")))
+	}
+	if r.MinYear > 0 {
+		parts = append(parts, "year>="+strconv.Itoa(r.MinYear))
+	}
+	if r.MaxYear > 0 {
+		parts = append(parts, "year<="+strconv.Itoa(r.MaxYear))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// HasListingRules returns whether any rules can only be checked against
+// scraped listings rather than the item's stats
+func (r Rules) HasListingRules() bool {
+	return r.MinMedia != GradeNotGraded || r.MinSleeve != GradeNotGraded ||
+		len(r.ShipsFrom) > 0 || len(r.NotShipsFrom) > 0 || r.MaxLanded > 0 ||
+		len(r.MaxByMedia) > 0 || r.SuggestedPercent > 0 || r.HasCommentRules()
+}
+
+// HasCommentRules returns whether listings are filtered by their comments
+func (r Rules) HasCommentRules() bool {
+	return len(r.Keywords) > 0 || len(r.NotKeywords) > 0 || r.Match != "" || r.NotMatch != ""
+}
+
+// HasReleaseRules returns whether items are filtered by their release's
+// metadata
+func (r Rules) HasReleaseRules() bool {
+	return len(r.Formats) > 0 || len(r.Genres) > 0 || r.MinYear > 0 || r.MaxYear > 0
+}
+
+// quoteRuleValue quotes a value containing whitespace so it's parsed as
+// one token
+func quoteRuleValue(value string) string {
+	if !strings.ContainsAny(value, " \t\n") {
+		return value
+	}
+	if strings.Contains(value, "'") {
+		return `"` + value + `"`
+	}
+
+	return "'" + value + "'"
+}
+
+// commentPatterns caches compiled comment rule expressions so each is
+// only compiled once however many listings they're matched against
+var commentPatterns sync.Map
+
+// compileCached compiles a regular expression or returns it from the
+// cache if it has been compiled before
+func compileCached(expr string) (*regexp.Regexp, error) {
+	if pattern, ok := commentPatterns.Load(expr); ok {
+		return pattern.(*regexp.Regexp), nil
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	commentPatterns.Store(expr, pattern)
+
+	return pattern, nil
+}
+
+// compileCommentPattern compiles a comment rule's regular expression,
+// ignoring case
+func compileCommentPattern(pattern string) (*regexp.Regexp, error) {
+	return compileCached("(?i)" + pattern)
+}
+
+// keywordPattern matches a keyword as whole words, ignoring case, so
+// 'sealed' doesn't match 'unsealed'
+func keywordPattern(keyword string) *regexp.Regexp {
+	// Quoted keywords always compile
+	pattern, _ := compileCached(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(keyword) + `([^\pL\pN]|$)`)
+
+	return pattern
+}
+
+// sortedGradings returns the gradings of tiers, best first
+func sortedGradings(tiers map[Grading]float64) []Grading {
+	gradings := []Grading{}
+	for grading := range tiers {
+		gradings = append(gradings, grading)
+	}
+	sort.Slice(gradings, func(i, j int) bool {
+		return gradings[i] > gradings[j]
+	})
+
+	return gradings
+}
+
+// ConditionMaxPrice returns the maximum price of a listing with the given
+// media and sleeve conditions, from the best media tier no better than
+// the media plus the adjustment of the worst sleeve tier no worse than
+// the sleeve. It's false if the media is worse than every tier
+func (r Rules) ConditionMaxPrice(media, sleeve Grading) (float64, bool) {
+	mediaTier, sleeveTier := GradeNotGraded, GradeNotGraded
+
+	for grading := range r.MaxByMedia {
+		if media.AtLeast(grading) && grading > mediaTier {
+			mediaTier = grading
+		}
+	}
+	if mediaTier == GradeNotGraded {
+		return 0, false
+	}
+
+	// Ungraded sleeves aren't known to be worse so aren't adjusted
+	for grading := range r.SleeveAdjustments {
+		if sleeve != GradeNotGraded && sleeve <= grading && (sleeveTier == GradeNotGraded || grading < sleeveTier) {
+			sleeveTier = grading
+		}
+	}
+
+	return r.MaxByMedia[mediaTier] + r.SleeveAdjustments[sleeveTier], true
+}
+
+// HighestConditionPrice returns the most any listing could cost and meet
+// the condition tiers, 0 if there are none
+func (r Rules) HighestConditionPrice() float64 {
+	highest, bonus := 0.0, 0.0
+
+	for _, price := range r.MaxByMedia {
+		if price > highest {
+			highest = price
+		}
+	}
+	for _, adjustment := range r.SleeveAdjustments {
+		if adjustment > bonus {
+			bonus = adjustment
+		}
+	}
+
+	if highest == 0 {
+		return 0
+	}
+
+	return highest + bonus
+}
@@ -0,0 +1,190 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type RulesCase struct {
+	Text     string
+	Expected Rules
+	Valid    bool
+}
+
+func TestParseRules(t *testing.T) {
+	cases := []RulesCase{
+		RulesCase{Text: "", Expected: Rules{}, Valid: true},
+		RulesCase{Text: "30.50", Expected: Rules{MaxPrice: 30.5}, Valid: true},
+		RulesCase{Text: "max=45", Expected: Rules{MaxPrice: 45}, Valid: true},
+		RulesCase{Text: " MAX='20' \n", Expected: Rules{MaxPrice: 20}, Valid: true},
+		RulesCase{Text: "max=cheap", Valid: false},
+		RulesCase{Text: "colour=red", Valid: false},
+		RulesCase{Text: "max=\"30", Valid: false},
+		RulesCase{Text: "media>=VG+ sleeve>=g", Expected: Rules{MinMedia: GradeVeryGoodPlus, MinSleeve: GradeGood}, Valid: true},
+		RulesCase{Text: "25 media>='Near Mint'", Expected: Rules{MaxPrice: 25, MinMedia: GradeNearMint}, Valid: true},
+		RulesCase{Text: "media>=great", Valid: false},
+		RulesCase{Text: "sleeve>=Generic", Valid: false},
+		RulesCase{Text: "ships=eu,United_Kingdom,de", Expected: Rules{ShipsFrom: []string{"EU", "GB", "DE"}}, Valid: true},
+		RulesCase{Text: "ships='north america' !ships=US", Expected: Rules{ShipsFrom: []string{"NorthAmerica"}, NotShipsFrom: []string{"US"}}, Valid: true},
+		RulesCase{Text: "ships=Atlantis", Valid: false},
+		RulesCase{Text: "landed=45.5 30", Expected: Rules{MaxPrice: 30, MaxLanded: 45.5}, Valid: true},
+		RulesCase{Text: "landed=free", Valid: false},
+		RulesCase{Text: "max.NM=45 max.vg+=30 'max.Very Good=15' sleeve.VG=-5 sleeve.Generic=-10", Expected: Rules{
+			MaxByMedia:        map[Grading]float64{GradeNearMint: 45, GradeVeryGoodPlus: 30, GradeVeryGood: 15},
+			SleeveAdjustments: map[Grading]float64{GradeVeryGood: -5, GradeGeneric: -10},
+		}, Valid: true},
+		RulesCase{Text: "max.Generic=10", Valid: false},
+		RulesCase{Text: "max.VG=-5", Valid: false},
+		RulesCase{Text: "sleeve.Shiny=-5", Valid: false},
+		RulesCase{Text: "colour.VG=5", Valid: false},
+		RulesCase{Text: "median=80% drop=10 suggested=95.5%", Expected: Rules{MedianPercent: 80, DropPercent: 10, SuggestedPercent: 95.5}, Valid: true},
+		RulesCase{Text: "median=lots", Valid: false},
+		RulesCase{Text: "drop=100%", Valid: false},
+		RulesCase{Text: "suggested=-5%", Valid: false},
+		RulesCase{Text: "has=Sealed,promo !has='warped, skips,no inner sleeve' match=shrink.?wrap !match='(?:small|light) scuff'", Expected: Rules{
+			Keywords:    []string{"Sealed", "promo"},
+			NotKeywords: []string{"warped", "skips", "no inner sleeve"},
+			Match:       "shrink.?wrap",
+			NotMatch:    "(?:small|light) scuff",
+		}, Valid: true},
+		RulesCase{Text: "has=,", Valid: false},
+		RulesCase{Text: "format='LP,12\"' genre='Jazz, Dub' year>=1970 year<=1979", Expected: Rules{
+			Formats: []string{"LP", "12\""},
+			Genres:  []string{"Jazz", "Dub"},
+			MinYear: 1970,
+			MaxYear: 1979,
+		}, Valid: true},
+		RulesCase{Text: "format=", Valid: false},
+		RulesCase{Text: "year>=sixties", Valid: false},
+		RulesCase{Text: "match=(unclosed", Valid: false},
+	}
+
+	for _, _case := range cases {
+		rules, err := ParseRules(_case.Text)
+		if err != nil {
+			if _case.Valid {
+				t.Errorf("Expected '%s' to be valid, got %v", _case.Text, err)
+			}
+			continue
+		} else if !_case.Valid {
+			t.Errorf("Expected '%s' to be invalid", _case.Text)
+			continue
+		}
+
+		if !cmp.Equal(rules, _case.Expected) {
+			t.Errorf("Expected rules %v for '%s', got %v", _case.Expected, _case.Text, rules)
+		}
+	}
+}
+
+func TestParseListRules(t *testing.T) {
+	rules, err := ParseListRules("Records I'm after, notify_me max=40 (30 for singles)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rules.MaxPrice != 40 {
+		t.Errorf("Expected max price 40, got %f", rules.MaxPrice)
+	}
+
+	if _, err := ParseListRules("notify_me max=lots"); err == nil {
+		t.Error("Expected error for invalid list rule")
+	}
+}
+
+func TestRulesMerge(t *testing.T) {
+	list := Rules{MaxPrice: 40}
+
+	if merged := list.Merge(Rules{}); merged.MaxPrice != 40 {
+		t.Errorf("Expected list max price 40 to be kept, got %f", merged.MaxPrice)
+	}
+
+	if merged := list.Merge(Rules{MaxPrice: 25}); merged.MaxPrice != 25 {
+		t.Errorf("Expected item max price 25 to override, got %f", merged.MaxPrice)
+	}
+
+	if s := list.String(); s != "max=40" {
+		t.Errorf("Expected 'max=40', got '%s'", s)
+	}
+
+	merged := Rules{MaxPrice: 40, MinMedia: GradeVeryGood}.Merge(Rules{MinMedia: GradeNearMint, MinSleeve: GradeGoodPlus})
+	if s := merged.String(); s != "max=40 media>=NM sleeve>=G+" {
+		t.Errorf("Expected 'max=40 media>=NM sleeve>=G+', got '%s'", s)
+	}
+
+	merged = Rules{MaxPrice: 40}.Merge(Rules{MaxLanded: 50})
+	if s := merged.String(); s != "max=40 landed=50" {
+		t.Errorf("Expected 'max=40 landed=50', got '%s'", s)
+	}
+
+	merged = Rules{MedianPercent: 80, DropPercent: 5}.Merge(Rules{DropPercent: 10, SuggestedPercent: 90})
+	if s := merged.String(); s != "median=80% drop=10% suggested=90%" {
+		t.Errorf("Expected 'median=80%% drop=10%% suggested=90%%', got '%s'", s)
+	}
+
+	merged = Rules{Keywords: []string{"sealed"}, NotMatch: "skip"}.Merge(Rules{NotKeywords: []string{"no inner sleeve", "warp"}})
+	if s := merged.String(); s != "has=sealed !has='no inner sleeve,warp' !match=skip" {
+		t.Errorf("Expected \"has=sealed !has='no inner sleeve,warp' !match=skip\", got '%s'", s)
+	}
+
+	if reparsed, err := ParseRules(merged.String()); err != nil || !cmp.Equal(reparsed, merged) {
+		t.Errorf("Expected formatted rules to parse back to %v, got %v (%v)", merged, reparsed, err)
+	}
+
+	merged = Rules{Formats: []string{"LP"}, MinYear: 1970}.Merge(Rules{Genres: []string{"Jazz", "Free Jazz"}, MinYear: 1965, MaxYear: 1979})
+	if s := merged.String(); s != "format=LP genre='Jazz,Free Jazz' year>=1965 year<=1979" {
+		t.Errorf("Expected \"format=LP genre='Jazz,Free Jazz' year>=1965 year<=1979\", got '%s'", s)
+	}
+
+	if reparsed, err := ParseRules(merged.String()); err != nil || !cmp.Equal(reparsed, merged) {
+		t.Errorf("Expected formatted rules to parse back to %v, got %v (%v)", merged, reparsed, err)
+	}
+
+	merged = Rules{ShipsFrom: []string{"EU"}}.Merge(Rules{NotShipsFrom: []string{"DE"}})
+	if s := merged.String(); s != "ships=EU !ships=DE" {
+		t.Errorf("Expected 'ships=EU !ships=DE', got '%s'", s)
+	}
+
+	merged = Rules{MaxByMedia: map[Grading]float64{GradeMint: 50}}.Merge(Rules{
+		MaxByMedia:        map[Grading]float64{GradeNearMint: 45, GradeVeryGoodPlus: 30},
+		SleeveAdjustments: map[Grading]float64{GradeGeneric: -10},
+	})
+	if s := merged.String(); s != "max.NM=45 max.VG+=30 sleeve.Generic=-10" {
+		t.Errorf("Expected 'max.NM=45 max.VG+=30 sleeve.Generic=-10', got '%s'", s)
+	}
+}
+
+func TestConditionMaxPrice(t *testing.T) {
+	rules, err := ParseRules("max.NM=45 max.VG+=30 max.VG=15 sleeve.VG=-5 sleeve.Generic=-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Media    Grading
+		Sleeve   Grading
+		Expected float64
+		OK       bool
+	}{
+		{GradeMint, GradeMint, 45, true},
+		{GradeNearMint, GradeVeryGoodPlus, 45, true},
+		{GradeVeryGoodPlus, GradeVeryGood, 25, true},
+		{GradeVeryGoodPlus, GradeGood, 25, true},
+		{GradeVeryGood, GradeNoCover, 5, true},
+		{GradeNearMint, GradeNotGraded, 45, true},
+		{GradeGoodPlus, GradeVeryGood, 0, false},
+		{GradeNotGraded, GradeMint, 0, false},
+	}
+
+	for _, _case := range cases {
+		max, ok := rules.ConditionMaxPrice(_case.Media, _case.Sleeve)
+		if max != _case.Expected || ok != _case.OK {
+			t.Errorf("Expected %.2f (%t) for %s/%s, got %.2f (%t)", _case.Expected, _case.OK, _case.Media.Abbreviation(), _case.Sleeve.Abbreviation(), max, ok)
+		}
+	}
+
+	if highest := rules.HighestConditionPrice(); highest != 45 {
+		t.Errorf("Expected highest condition price 45, got %.2f", highest)
+	}
+}
@@ -1,11 +1,12 @@
 package notifier
 
 import (
+	"context"
 	"fmt"
 	"net/http"
-	"regexp"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	log "github.com/sirupsen/logrus"
@@ -15,8 +16,8 @@ type WantListItem struct {
 	ID                 string
 	BlockedSellers     []string
 	MaxPrice           int
-	MinMediaCondition  int
-	MinSleeveCondition int
+	MinMediaCondition  Grading
+	MinSleeveCondition Grading
 	PreviousResults    []ListedItem
 }
 
@@ -27,29 +28,43 @@ type WantList struct {
 }
 
 type ListedItem struct {
-	ID              string
-	Seller          string
-	Location        string
-	Price           int
-	MediaCondition  int
-	SleeveCondition int
-}
-
-var ConditionMap = map[string]int{
-	"Generic":        1,
-	"Poor":           2,
-	"Fair":           3,
-	"Good Plus":      5,
-	"Good":           4,
-	"Very Good Plus": 7,
-	"Very Good":      6,
-	"Near Mint":      8,
-	"Mint":           9,
-}
-
-func FetchListedItemDocument(url string) (*goquery.Document, error) {
+	ID       string
+	Seller   string
+	SellerID string
+	Location string
+	// Country is the ISO code of the location, empty if it isn't known
+	Country string
+	// Price is converted to the user's currency in cents, excluding shipping
+	Price int
+	// OriginalPrice and Shipping are in cents of the seller's currency
+	OriginalPrice    int
+	OriginalCurrency string
+	Shipping         int
+	// ConvertedShipping is the shipping cost in cents of the user's currency
+	ConvertedShipping int
+	// Landed is the estimated cost delivered to the user, set by the
+	// notifier after scraping
+	Landed          LandedCost
+	MediaCondition  Grading
+	SleeveCondition Grading
+	Comment         string
+	Format          string
+	Photos          int
+	AcceptsOffers   bool
+	// Unavailable is set if the seller doesn't ship to the user's country
+	Unavailable bool
+}
+
+// FetchListedItemDocument fetches and parses a sell page with client,
+// giving up once ctx is done
+func FetchListedItemDocument(ctx context.Context, client *http.Client, url string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	// Request the HTML page.
-	res, err := http.Get(url)
+	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -69,97 +84,320 @@ func FetchListedItemDocument(url string) (*goquery.Document, error) {
 	return doc, err
 }
 
-func StringToCondition(input string) int {
-	for text, condition := range ConditionMap {
-		if strings.Contains(input, text) {
-			return condition
-		}
+// parseCondition parses a scraped condition, conditions that can't be
+// parsed are not graded
+func parseCondition(text string) Grading {
+	grading, err := ParseGrading(text)
+	if err != nil {
+		return GradeNotGraded
 	}
 
-	return 0
+	return grading
 }
 
+// StringToPrice parses a price in hundredths of its currency, see ParseMoney
 func StringToPrice(input string) (int, error) {
-
-	re := regexp.MustCompile(`[0-9]+(\.[0-9][0-9]?)?`)
-
-	priceString := strings.ReplaceAll(re.FindString(input), ".", "")
-
-	price, err := strconv.Atoi(priceString)
+	money, err := ParseMoney(input)
 	if err != nil {
 		return 0, err
 	}
 
-	return price, nil
+	return money.Cents(), nil
 }
 
+// FindPriceFromSelection finds the price of a listing with the default
+// selector profile
 func FindPriceFromSelection(s *goquery.Selection) (int, error) {
-	rawPrice, err := StringToPrice(s.Find(".price").Text())
+	prices, err := DefaultSelectorProfile().findPrices(s, true)
+
+	return prices.converted, err
+}
+
+// listingPrices are the prices of a listing
+type listingPrices struct {
+	original Money
+	shipping Money
+	// converted and convertedShipping are in hundredths of the user's
+	// currency
+	converted         int
+	convertedShipping int
+}
+
+// findPrices returns the prices of a listing. The converted price is in
+// the user's currency, excluding shipping. Listings that can't be shipped
+// to the user have no shipping cost so it isn't required
+func (p *SelectorProfile) findPrices(s *goquery.Selection, requireShipping bool) (listingPrices, error) {
+	prices := listingPrices{}
+
+	original, err := ParseMoney(s.Find(p.Price).Text())
 	if err != nil {
-		return 0, err
+		return prices, err
 	}
 
-	shippingPrice, err := StringToPrice(s.Find(".item_shipping").Text())
-	if err != nil {
-		return 0, err
+	shipping, err := ParseMoney(s.Find(p.Shipping).Text())
+	if err != nil && requireShipping {
+		return prices, err
 	}
 
-	convertedPrice, err := StringToPrice(s.Find(".converted_price").Text())
+	converted, err := ParseMoney(s.Find(p.ConvertedPrice).Text())
 	if err != nil {
-		return 0, err
+		return prices, err
 	}
 
-	floatPrice := (float32(rawPrice) / (float32(rawPrice) + float32(shippingPrice))) * float32(convertedPrice)
+	// The converted price includes shipping, so take the share of it that
+	// is the listing's price
+	rawPrice, shippingPrice := float32(original.Amount), float32(shipping.Amount)
+	floatPrice := (rawPrice / (rawPrice + shippingPrice)) * float32(converted.Cents())
+
+	prices.original = original
+	prices.shipping = shipping
+	prices.converted = int(floatPrice)
+	prices.convertedShipping = converted.Cents() - prices.converted
 
-	return int(floatPrice), nil
+	return prices, nil
 }
 
+// ParseReport describes how well a sell page parsed, so changes to the
+// page's markup are noticed rather than silently yielding no listings
+type ParseReport struct {
+	// Listings is the number of listing rows found
+	Listings int
+	// Items is the number of listings parsed, including unavailable
+	Items int
+	// Failed is the number of listings which couldn't be parsed
+	Failed int
+	// Missing counts the parsed listings missing each optional field
+	Missing map[string]int
+}
+
+// Problems returns the problems with a parsed sell page of a release
+// with numForSale listings
+func (r ParseReport) Problems(numForSale int) []string {
+	problems := []string{}
+
+	if r.Listings == 0 && numForSale > 0 {
+		problems = append(problems, fmt.Sprintf("no listings found, %d for sale", numForSale))
+	}
+
+	if r.Failed > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d listings couldn't be parsed", r.Failed, r.Items+r.Failed))
+	}
+
+	fields := []string{}
+	for field := range r.Missing {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		problems = append(problems, fmt.Sprintf("%s missing from %d of %d listings", field, r.Missing[field], r.Items))
+	}
+
+	return problems
+}
+
+// FindItemsFromDoc finds the listings in a sell page with the default
+// selector profile
 func FindItemsFromDoc(doc *goquery.Document) []ListedItem {
+	items, _ := DefaultSelectorProfile().FindItems(doc)
+
+	return items
+}
 
+// FindItems finds the listings in a sell page
+func (p *SelectorProfile) FindItems(doc *goquery.Document) ([]ListedItem, ParseReport) {
 	items := []ListedItem{}
+	report := ParseReport{Missing: map[string]int{}}
 
-	doc.Find(".shortcut_navigable:not(.unavailable)").Each(func(i int, s *goquery.Selection) {
-		idHref, ok := s.Find(".item_description_title").Attr("href")
+	doc.Find(p.Listing).Each(func(i int, s *goquery.Selection) {
+		report.Listings++
+
+		available := !s.Is(p.Unavailable)
+
+		idHref, ok := s.Find(p.ItemLink).Attr("href")
 		if !ok {
 			log.Warn("Missing href when finding item ID")
+			scrapeParseFailures.Inc()
+			report.Failed++
 			return
 		}
 
-		id := strings.ReplaceAll(idHref, "/sell/item/", "")
+		id := strings.ReplaceAll(idHref, p.ItemLinkPrefix, "")
 
-		mediaCondition := StringToCondition(s.Find(".item_condition span:nth-child(3)").Text())
-		sleeveCondition := StringToCondition(s.Find(".item_condition span:nth-child(7)").Text())
+		mediaCondition := parseCondition(s.Find(p.MediaCondition).Text())
+		sleeveCondition := parseCondition(s.Find(p.SleeveCondition).Text())
 
-		price, err := FindPriceFromSelection(s)
+		prices, err := p.findPrices(s, available)
 		if err != nil {
 			log.Warn(err)
+			scrapeParseFailures.Inc()
+			report.Failed++
 			return
 		}
 
-		seller := s.Find(".seller_info li:nth-child(1) strong").Text()
-		location := strings.ReplaceAll(s.Find(".seller_info li:nth-child(3)").Text(), "Ships From:", "")
+		// Prefer the currency given by the page to one detected in the price
+		originalCurrency := extract(s, p.Currency, nil)
+		if originalCurrency == "" {
+			originalCurrency = prices.original.Currency
+		}
+
+		seller := strings.TrimSpace(s.Find(p.Seller).Text())
+		location := strings.TrimSpace(strings.ReplaceAll(s.Find(p.Location).Text(), p.LocationPrefix, ""))
+
+		country, ok := CountryCode(location)
+		if !ok && location != "" {
+			log.Debugf("Unknown country '%s' for listing %s", location, id)
+		}
 
 		item := ListedItem{
-			ID:              id,
-			MediaCondition:  mediaCondition,
-			SleeveCondition: sleeveCondition,
-			Price:           price,
-			Seller:          seller,
-			Location:        location,
+			ID:                id,
+			MediaCondition:    mediaCondition,
+			SleeveCondition:   sleeveCondition,
+			Price:             prices.converted,
+			OriginalPrice:     prices.original.Cents(),
+			OriginalCurrency:  originalCurrency,
+			Shipping:          prices.shipping.Cents(),
+			ConvertedShipping: prices.convertedShipping,
+			Seller:            seller,
+			SellerID:          extract(s, p.SellerID, p.sellerIDPattern),
+			Location:          location,
+			Country:           country,
+			Comment:           extract(s, p.Comment, nil),
+			Format:            extract(s, p.Format, p.formatPattern),
+			Photos:            countPhotos(extract(s, p.Photos, nil)),
+			AcceptsOffers:     p.AcceptsOffers != "" && s.Find(p.AcceptsOffers).Length() > 0,
+			Unavailable:       !available,
+		}
+
+		// Sleeves can be ungraded so only the media condition is expected
+		if !mediaCondition.IsGrade() {
+			report.Missing["media_condition"]++
+		}
+		if seller == "" {
+			report.Missing["seller"]++
+		}
+		if location == "" {
+			report.Missing["location"]++
 		}
 
+		report.Items++
 		items = append(items, item)
 	})
 
-	return items
+	return items, report
 }
 
-func ScrapeListedItems(id string) ([]ListedItem, error) {
+// HasNextPage returns whether a sell page links to a further page
+func (p *SelectorProfile) HasNextPage(doc *goquery.Document) bool {
+	return doc.Find(p.NextPage).Length() > 0
+}
 
-	doc, err := FetchListedItemDocument("https://www.discogs.com/sell/release/" + id)
-	if err != nil {
-		return nil, err
+// Number of listings requested per sell page
+const sellPageLimit = 100
+
+// DefaultWebURL is the discogs website sell pages are scraped from
+const DefaultWebURL = "https://www.discogs.com"
+
+// SellPageURL returns the url of a page of a release's listings, newest
+// listings first
+func SellPageURL(id string, page int) string {
+	return sellPageURL(DefaultWebURL, id, page)
+}
+
+// sellPageURL returns the url of a sell page on the website at base
+func sellPageURL(base, id string, page int) string {
+	return fmt.Sprintf("%s/sell/release/%s?sort=listed%%2Cdesc&limit=%d&page=%d", base, id, sellPageLimit, page)
+}
+
+// ScrapeOptions controls how far through a release's sell pages to scrape
+type ScrapeOptions struct {
+	// MaxPages is the most pages scraped, popular releases are truncated
+	MaxPages int
+	// Seen are the listings scraped in the previous run. Listings are
+	// newest first so scraping stops at the page where they're reached
+	Seen []string
+	// Wait is called before fetching each page e.g. to rate limit
+	Wait func()
+	// HTTPClient fetches the pages, defaults to a client with a timeout
+	HTTPClient *http.Client
+	// WebURL is the website the pages are fetched from, defaults to
+	// DefaultWebURL
+	WebURL string
+}
+
+// ScrapePages finds the listings in successive sell pages returned by
+// fetch, stopping at the last page, options.MaxPages or the first page
+// with a listing in options.Seen. The parse reports of each page are
+// combined
+func (p *SelectorProfile) ScrapePages(fetch func(page int) (*goquery.Document, error), options ScrapeOptions) ([]ListedItem, ParseReport, error) {
+	seen := map[string]bool{}
+	for _, id := range options.Seen {
+		seen[id] = true
+	}
+
+	items := []ListedItem{}
+	report := ParseReport{Missing: map[string]int{}}
+
+	for page := 1; ; page++ {
+		doc, err := fetch(page)
+		if err != nil {
+			return nil, report, err
+		}
+
+		pageItems, pageReport := p.FindItems(doc)
+		items = append(items, pageItems...)
+		report.add(pageReport)
+
+		reachedSeen := false
+		for _, item := range pageItems {
+			if seen[item.ID] {
+				reachedSeen = true
+				break
+			}
+		}
+
+		if reachedSeen || !p.HasNextPage(doc) {
+			break
+		}
+
+		if page >= options.MaxPages {
+			log.Debugf("Stopped scraping after %d pages, later listings are ignored", page)
+			break
+		}
 	}
 
-	return FindItemsFromDoc(doc), nil
+	return items, report, nil
+}
+
+// add combines the report of another page into r
+func (r *ParseReport) add(page ParseReport) {
+	r.Listings += page.Listings
+	r.Items += page.Items
+	r.Failed += page.Failed
+
+	for field, count := range page.Missing {
+		r.Missing[field] += count
+	}
+}
+
+// ScrapeListedItems scrapes the listings of a release from its sell pages,
+// stopping if ctx is done
+func ScrapeListedItems(ctx context.Context, id string, profile *SelectorProfile, options ScrapeOptions) ([]ListedItem, ParseReport, error) {
+	client := options.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	base := options.WebURL
+	if base == "" {
+		base = DefaultWebURL
+	}
+
+	return profile.ScrapePages(func(page int) (*goquery.Document, error) {
+		if options.Wait != nil {
+			options.Wait()
+		}
+
+		return FetchListedItemDocument(ctx, client, sellPageURL(base, id, page))
+	}, options)
 }
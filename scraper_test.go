@@ -0,0 +1,257 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/google/go-cmp/cmp"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// scrapeGolden is the expected output of scraping a sell page fixture
+type scrapeGolden struct {
+	Items  []ListedItem
+	Report ParseReport
+}
+
+func TestFindItemsGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "sell", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fixtures) == 0 {
+		t.Fatal("Expected sell page fixtures")
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			f, err := os.Open(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			doc, err := goquery.NewDocumentFromReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			items, report := DefaultSelectorProfile().FindItems(doc)
+			got := scrapeGolden{Items: items, Report: report}
+
+			goldenPath := strings.TrimSuffix(fixture, ".html") + ".golden.json"
+			if *update {
+				data, err := json.MarshalIndent(got, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(goldenPath, append(data, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := ioutil.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("Unable to read golden file, run with -update to create it: %v", err)
+			}
+
+			var expected scrapeGolden
+			if err := json.Unmarshal(data, &expected); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(expected, got); diff != "" {
+				t.Errorf("Scraped listings differ from %s (-expected +got):\n%s", goldenPath, diff)
+			}
+		})
+	}
+}
+
+func TestParseReportProblems(t *testing.T) {
+	tests := []struct {
+		name       string
+		report     ParseReport
+		numForSale int
+		expected   []string
+	}{
+		{"healthy", ParseReport{Listings: 2, Items: 2}, 2, []string{}},
+		{"nothing for sale", ParseReport{}, 0, []string{}},
+		{"no listings", ParseReport{}, 3, []string{"no listings found, 3 for sale"}},
+		{
+			"failures and missing fields",
+			ParseReport{Listings: 3, Items: 2, Failed: 1, Missing: map[string]int{"seller": 1, "location": 2}},
+			3,
+			[]string{"1 of 3 listings couldn't be parsed", "location missing from 2 of 2 listings", "seller missing from 1 of 2 listings"},
+		},
+	}
+
+	for _, test := range tests {
+		if problems := test.report.Problems(test.numForSale); !cmp.Equal(problems, test.expected) {
+			t.Errorf("%s: Expected problems %q, got %q", test.name, test.expected, problems)
+		}
+	}
+}
+
+func TestLoadSelectorProfile(t *testing.T) {
+	dir := t.TempDir()
+
+	profile, err := LoadSelectorProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Version != selectorProfileVersion || profile.Listing == "" {
+		t.Errorf("Expected default profile, got %v", profile)
+	}
+
+	// Overrides only need the selectors that changed
+	path := filepath.Join(dir, "selectors.yaml")
+	ioutil.WriteFile(path, []byte("revision: local\nseller: .seller_name\n"), 0644)
+
+	profile, err = LoadSelectorProfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Seller != ".seller_name" || profile.Revision != "local" || profile.Price != DefaultSelectorProfile().Price {
+		t.Errorf("Expected seller selector overridden over the defaults, got %v", profile)
+	}
+
+	for _, invalid := range []string{"version: 2\n", "selers: .seller_name\n", "format_pattern: \\((\n"} {
+		ioutil.WriteFile(path, []byte(invalid), 0644)
+
+		if _, err := LoadSelectorProfile(path); err == nil {
+			t.Errorf("Expected error loading profile %q", invalid)
+		}
+	}
+}
+
+func TestHasNextPage(t *testing.T) {
+	expected := map[string]bool{"release.html": true, "renamed_link.html": false}
+
+	for fixture, hasNext := range expected {
+		f, err := os.Open(filepath.Join("testdata", "sell", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		doc, err := goquery.NewDocumentFromReader(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := DefaultSelectorProfile().HasNextPage(doc); got != hasNext {
+			t.Errorf("%s: Expected next page %v, got %v", fixture, hasNext, got)
+		}
+	}
+}
+
+// sellPage returns a sell page with a listing for each ID
+func sellPage(t *testing.T, hasNext bool, ids ...string) *goquery.Document {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(sellPageHTML(hasNext, ids...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+// sellPageHTML returns the html of a sell page with a listing for each ID
+func sellPageHTML(hasNext bool, ids ...string) string {
+	var page strings.Builder
+	page.WriteString("<table>")
+	for _, id := range ids {
+		page.WriteString(`<tr class="shortcut_navigable"><td>` +
+			`<a class="item_description_title" href="/sell/item/` + id + `"></a>` +
+			`<p class="item_condition"><span></span><span></span><span>Mint (M)</span></p></td>` +
+			`<td class="seller_info"><ul><li><strong>seller</strong></li><li></li><li>Ships From:UK</li></ul></td>` +
+			`<td><span class="price">£10.00</span><span class="item_shipping">+£2.00</span><span class="converted_price">about A$24.00</span></td></tr>`)
+	}
+	page.WriteString("</table>")
+	if hasNext {
+		page.WriteString(`<a class="pagination_next" href="#">Next</a>`)
+	}
+
+	return page.String()
+}
+
+func TestScrapePages(t *testing.T) {
+	// Newest listings first, 3 pages in total
+	pages := [][]string{{"6", "5"}, {"4", "3"}, {"2", "1"}}
+
+	tests := []struct {
+		name     string
+		options  ScrapeOptions
+		expected int
+		fetched  int
+	}{
+		{"first run is limited to max pages", ScrapeOptions{MaxPages: 2}, 4, 2},
+		{"every page", ScrapeOptions{MaxPages: 5}, 6, 3},
+		{"stops at seen listings", ScrapeOptions{MaxPages: 5, Seen: []string{"3", "2", "1"}}, 4, 2},
+		{"nothing new", ScrapeOptions{MaxPages: 5, Seen: []string{"6"}}, 2, 1},
+	}
+
+	for _, test := range tests {
+		fetched := 0
+		fetch := func(page int) (*goquery.Document, error) {
+			fetched++
+			return sellPage(t, page < len(pages), pages[page-1]...), nil
+		}
+
+		items, report, err := DefaultSelectorProfile().ScrapePages(fetch, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != test.expected || report.Items != test.expected || fetched != test.fetched {
+			t.Errorf("%s: Expected %d listings from %d pages, got %d (%d parsed) from %d pages",
+				test.name, test.expected, test.fetched, len(items), report.Items, fetched)
+		}
+	}
+}
+
+func TestScrapeListedItems(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sell/release/42" {
+			// Slow pages only respond once the request is cancelled
+			<-r.Context().Done()
+			return
+		}
+
+		fmt.Fprint(w, sellPageHTML(false, "2", "1"))
+	}))
+	defer ts.Close()
+
+	options := ScrapeOptions{MaxPages: 1, WebURL: ts.URL}
+
+	items, _, err := ScrapeListedItems(context.Background(), "42", DefaultSelectorProfile(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Errorf("Expected 2 listings, got %v", items)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, _, err := ScrapeListedItems(ctx, "slow", DefaultSelectorProfile(), options); err == nil {
+		t.Error("Expected error scraping a page once the context is done")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected scrape to stop with its context, took %v", elapsed)
+	}
+}
@@ -0,0 +1,160 @@
+package notifier
+
+import (
+	_ "embed"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"gopkg.in/yaml.v2"
+)
+
+// Version of the selector profile format understood by the scraper
+const selectorProfileVersion = 1
+
+//go:embed selectors.yaml
+var defaultSelectors []byte
+
+// SelectorProfile holds the selectors used to scrape listings from
+// marketplace sell pages, so they can be fixed without a new release when
+// discogs changes its markup
+type SelectorProfile struct {
+	Version  int    `yaml:"version"`
+	Revision string `yaml:"revision"`
+
+	Listing         string `yaml:"listing"`
+	Unavailable     string `yaml:"unavailable"`
+	ItemLink        string `yaml:"item_link"`
+	ItemLinkPrefix  string `yaml:"item_link_prefix"`
+	MediaCondition  string `yaml:"media_condition"`
+	SleeveCondition string `yaml:"sleeve_condition"`
+	Price           string `yaml:"price"`
+	Shipping        string `yaml:"shipping"`
+	ConvertedPrice  string `yaml:"converted_price"`
+	Seller          string `yaml:"seller"`
+	Location        string `yaml:"location"`
+	LocationPrefix  string `yaml:"location_prefix"`
+	Currency        string `yaml:"currency"`
+	SellerID        string `yaml:"seller_id"`
+	SellerIDPattern string `yaml:"seller_id_pattern"`
+	Comment         string `yaml:"comment"`
+	Format          string `yaml:"format"`
+	FormatPattern   string `yaml:"format_pattern"`
+	Photos          string `yaml:"photos"`
+	AcceptsOffers   string `yaml:"accepts_offers"`
+	NextPage        string `yaml:"next_page"`
+
+	// Patterns compiled when the profile is loaded
+	sellerIDPattern *regexp.Regexp
+	formatPattern   *regexp.Regexp
+}
+
+// Matches the number of photos in text such as '3 photos'
+var photoCountPattern = regexp.MustCompile(`[0-9]+`)
+
+// compile compiles the profile's patterns so they aren't compiled for
+// every listing
+func (p *SelectorProfile) compile() error {
+	var err error
+	if p.sellerIDPattern, err = compilePattern(p.SellerIDPattern); err != nil {
+		return err
+	}
+	if p.formatPattern, err = compilePattern(p.FormatPattern); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// compilePattern compiles a profile pattern, an empty pattern is nil
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile(pattern)
+}
+
+// DefaultSelectorProfile returns the selector profile embedded in the binary
+func DefaultSelectorProfile() *SelectorProfile {
+	var profile SelectorProfile
+	if err := yaml.UnmarshalStrict(defaultSelectors, &profile); err != nil {
+		panic(fmt.Sprintf("invalid embedded selector profile: %v", err))
+	}
+	if err := profile.compile(); err != nil {
+		panic(fmt.Sprintf("invalid pattern in embedded selector profile: %v", err))
+	}
+
+	return &profile
+}
+
+// LoadSelectorProfile loads a selector profile from path over the default
+// profile, so it only needs the selectors that differ. An empty path uses
+// only the defaults
+func LoadSelectorProfile(path string) (*SelectorProfile, error) {
+	profile := DefaultSelectorProfile()
+	if path == "" {
+		return profile, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, profile); err != nil {
+		return nil, fmt.Errorf("invalid selector profile %s: %v", path, err)
+	}
+
+	if profile.Version != selectorProfileVersion {
+		return nil, fmt.Errorf("selector profile %s has version %d, expected %d", path, profile.Version, selectorProfileVersion)
+	}
+
+	if err := profile.compile(); err != nil {
+		return nil, fmt.Errorf("invalid pattern in selector profile %s: %v", path, err)
+	}
+
+	return profile, nil
+}
+
+// extract returns the value of a selector within a listing. The selector
+// may end with '@attribute' to take an attribute rather than the text.
+// If pattern is set the value is its first submatch, or empty if it
+// doesn't match
+func extract(s *goquery.Selection, selector string, pattern *regexp.Regexp) string {
+	if selector == "" {
+		return ""
+	}
+
+	var value string
+	if i := strings.LastIndex(selector, "@"); i >= 0 {
+		value, _ = s.Find(strings.TrimSpace(selector[:i])).First().Attr(strings.TrimSpace(selector[i+1:]))
+	} else {
+		value = s.Find(selector).First().Text()
+	}
+
+	value = strings.Join(strings.Fields(value), " ")
+
+	if pattern != nil {
+		match := pattern.FindStringSubmatch(value)
+		if len(match) < 2 {
+			return ""
+		}
+		value = match[1]
+	}
+
+	return value
+}
+
+// countPhotos returns the number of photos from text such as '3 photos'
+func countPhotos(text string) int {
+	count, err := strconv.Atoi(photoCountPattern.FindString(text))
+	if err != nil {
+		return 0
+	}
+
+	return count
+}
@@ -0,0 +1,282 @@
+package notifier
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLSMode is how an SMTP connection is secured
+type TLSMode string
+
+const (
+	// TLSModeNone never uses TLS
+	TLSModeNone TLSMode = "none"
+	// TLSModeStartTLS upgrades to TLS when the server supports STARTTLS
+	TLSModeStartTLS TLSMode = "starttls"
+	// TLSModeStartTLSRequired fails if the server doesn't support STARTTLS
+	TLSModeStartTLSRequired TLSMode = "starttls-required"
+	// TLSModeImplicit uses TLS from the start of the connection (port 465)
+	TLSModeImplicit TLSMode = "implicit"
+)
+
+// AuthMechanism is how the SMTP client authenticates with the server
+type AuthMechanism string
+
+const (
+	AuthNone    AuthMechanism = "none"
+	AuthPlain   AuthMechanism = "plain"
+	AuthLogin   AuthMechanism = "login"
+	AuthCRAMMD5 AuthMechanism = "cram-md5"
+)
+
+// SMTPTransport sends emails through an SMTP server
+type SMTPTransport struct {
+	Host      string
+	Port      int
+	TLSMode   TLSMode
+	Auth      AuthMechanism
+	Username  string
+	Password  string
+	From      string
+	To        []string
+	Bcc       []string
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// splitList splits a comma separated list, dropping empty values
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// LoadCABundle reads a PEM encoded CA bundle into a certificate pool
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// NewSMTPTransport creates an SMTPTransport from the smtp config
+func NewSMTPTransport(config SMTPConfig) (*SMTPTransport, error) {
+	transport := &SMTPTransport{
+		Host:     config.Address,
+		Port:     config.Port,
+		TLSMode:  config.TLSMode,
+		Auth:     config.Auth,
+		Username: config.Username,
+		Password: config.Password,
+		From:     config.From,
+		To:       config.To,
+		Bcc:      config.Bcc,
+		Timeout:  time.Duration(config.Timeout),
+	}
+
+	if config.CAFile != "" {
+		pool, err := LoadCABundle(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return transport, transport.Validate()
+}
+
+// Validate checks the transport settings are usable
+func (t *SMTPTransport) Validate() error {
+	switch t.TLSMode {
+	case TLSModeNone, TLSModeStartTLS, TLSModeStartTLSRequired, TLSModeImplicit:
+	default:
+		return fmt.Errorf("unknown SMTP TLS mode '%s'", t.TLSMode)
+	}
+
+	switch t.Auth {
+	case AuthNone, AuthPlain, AuthLogin, AuthCRAMMD5:
+	default:
+		return fmt.Errorf("unknown SMTP auth mechanism '%s'", t.Auth)
+	}
+
+	if len(t.Recipients()) == 0 {
+		return errors.New("no email recipients set")
+	}
+
+	return nil
+}
+
+// Recipients returns every envelope recipient, including Bcc
+func (t *SMTPTransport) Recipients() []string {
+	return append(append([]string{}, t.To...), t.Bcc...)
+}
+
+// tlsConfig returns the TLS config for the server
+func (t *SMTPTransport) tlsConfig() *tls.Config {
+	config := &tls.Config{}
+	if t.TLSConfig != nil {
+		config = t.TLSConfig.Clone()
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = t.Host
+	}
+
+	return config
+}
+
+// smtpAuth returns the smtp.Auth for the transport's auth mechanism
+func (t *SMTPTransport) smtpAuth() smtp.Auth {
+	switch t.Auth {
+	case AuthPlain:
+		return smtp.PlainAuth("", t.Username, t.Password, t.Host)
+	case AuthLogin:
+		return &loginAuth{username: t.Username, password: t.Password, host: t.Host}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(t.Username, t.Password)
+	}
+
+	return nil
+}
+
+// Send delivers a message to all recipients
+func (t *SMTPTransport) Send(msg []byte) error {
+	from, err := envelopeAddress(t.From)
+	if err != nil {
+		return err
+	}
+
+	recipients := []string{}
+	for _, recipient := range t.Recipients() {
+		address, err := envelopeAddress(recipient)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, address)
+	}
+
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	dialer := &net.Dialer{Timeout: t.Timeout}
+
+	var conn net.Conn
+	if t.TLSMode == TLSModeImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, t.tlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Bound the whole conversation so a stalled server can't hang a worker
+	if t.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(t.Timeout))
+	}
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if t.TLSMode == TLSModeStartTLS || t.TLSMode == TLSModeStartTLSRequired {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(t.tlsConfig()); err != nil {
+				return err
+			}
+		} else if t.TLSMode == TLSModeStartTLSRequired {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+	}
+
+	if auth := t.smtpAuth(); auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which isn't
+// provided by net/smtp but is still required by some servers
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like PlainAuth, refuse to send credentials over an unencrypted connection
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected LOGIN challenge '%s'", fromServer)
+}
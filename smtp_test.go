@@ -0,0 +1,413 @@
+package notifier
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newTestCertificate creates a self signed certificate for localhost and
+// writes it to a CA bundle file
+func newTestCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+// MockSMTPServer is a minimal in process SMTP server which records the
+// envelope and data of messages it receives
+type MockSMTPServer struct {
+	Listener  net.Listener
+	TLSConfig *tls.Config
+	StartTLS  bool
+	Username  string
+	Password  string
+
+	mu         sync.Mutex
+	from       string
+	recipients []string
+	data       string
+	authed     string
+	tls        bool
+}
+
+// NewMockSMTPServer starts a server, using implicit TLS if implicitTLS is set
+func NewMockSMTPServer(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *MockSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if implicitTLS {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	s := &MockSMTPServer{
+		Listener:  l,
+		TLSConfig: tlsConfig,
+		Username:  "user",
+		Password:  "pass",
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn, implicitTLS)
+		}
+	}()
+
+	t.Cleanup(func() { l.Close() })
+
+	return s
+}
+
+func (s *MockSMTPServer) Port() int {
+	return s.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *MockSMTPServer) handle(conn net.Conn, isTLS bool) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	reply("220 localhost mock SMTP")
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		args := strings.TrimSpace(strings.TrimPrefix(line, strings.SplitN(line, " ", 2)[0]))
+
+		switch command {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			if s.StartTLS && !isTLS {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN LOGIN CRAM-MD5")
+		case "STARTTLS":
+			reply("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.TLSConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+			isTLS = true
+		case "AUTH":
+			parts := strings.SplitN(args, " ", 2)
+			var username, password string
+			switch strings.ToUpper(parts[0]) {
+			case "PLAIN":
+				decoded, _ := base64.StdEncoding.DecodeString(parts[1])
+				fields := strings.Split(string(decoded), "\x00")
+				username, password = fields[1], fields[2]
+			case "LOGIN":
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				line, _ := readLine()
+				decoded, _ := base64.StdEncoding.DecodeString(line)
+				username = string(decoded)
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				line, _ = readLine()
+				decoded, _ = base64.StdEncoding.DecodeString(line)
+				password = string(decoded)
+			case "CRAM-MD5":
+				challenge := "<1234.5678@localhost>"
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+				line, _ := readLine()
+				decoded, _ := base64.StdEncoding.DecodeString(line)
+				fields := strings.SplitN(string(decoded), " ", 2)
+				mac := hmac.New(md5.New, []byte(s.Password))
+				mac.Write([]byte(challenge))
+				username = fields[0]
+				if fields[1] == hex.EncodeToString(mac.Sum(nil)) {
+					password = s.Password
+				}
+			}
+			if username != s.Username || password != s.Password {
+				reply("535 Authentication failed")
+				continue
+			}
+			s.mu.Lock()
+			s.authed = strings.ToUpper(parts[0])
+			s.mu.Unlock()
+			reply("235 Authentication successful")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = strings.Trim(args[len("FROM:"):], "<>")
+			s.tls = isTLS
+			s.mu.Unlock()
+			reply("250 OK")
+		case "RCPT":
+			s.mu.Lock()
+			s.recipients = append(s.recipients, strings.Trim(args[len("TO:"):], "<>"))
+			s.mu.Unlock()
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data := []string{}
+			for {
+				line, err := readLine()
+				if err != nil || line == "." {
+					break
+				}
+				data = append(data, line)
+			}
+			s.mu.Lock()
+			s.data = strings.Join(data, "\r\n")
+			s.mu.Unlock()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *MockSMTPServer) Received() (from string, recipients []string, data, authed string, isTLS bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.from, s.recipients, s.data, s.authed, s.tls
+}
+
+func TestSMTPTransport(t *testing.T) {
+	cert, caFile := newTestCertificate(t)
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	pool, err := LoadCABundle(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name        string
+		ImplicitTLS bool
+		StartTLS    bool
+		TLSMode     TLSMode
+		Auth        AuthMechanism
+		ExpectTLS   bool
+	}{
+		{"Unauthenticated relay", false, false, TLSModeNone, AuthNone, false},
+		{"Opportunistic STARTTLS with PLAIN", false, true, TLSModeStartTLS, AuthPlain, true},
+		{"Required STARTTLS with LOGIN", false, true, TLSModeStartTLSRequired, AuthLogin, true},
+		{"Implicit TLS with CRAM-MD5", true, false, TLSModeImplicit, AuthCRAMMD5, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			server := NewMockSMTPServer(t, serverTLS, c.ImplicitTLS)
+			server.StartTLS = c.StartTLS
+
+			transport := &SMTPTransport{
+				Host:      "localhost",
+				Port:      server.Port(),
+				TLSMode:   c.TLSMode,
+				Auth:      c.Auth,
+				Username:  "user",
+				Password:  "pass",
+				From:      "Discogs Notifier <notifier@example.com>",
+				To:        []string{"a@example.com", "B <b@example.com>"},
+				Bcc:       []string{"c@example.com"},
+				TLSConfig: &tls.Config{RootCAs: pool},
+				Timeout:   5 * time.Second,
+			}
+
+			msg := "Subject: test\r\n\r\nHello"
+			if err := transport.Send([]byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+
+			from, recipients, data, authed, isTLS := server.Received()
+
+			// The envelope has the bare addresses without display names
+			if from != "notifier@example.com" {
+				t.Errorf("Expected from notifier@example.com, got %s", from)
+			}
+
+			expectedRecipients := []string{"a@example.com", "b@example.com", "c@example.com"}
+			if !cmp.Equal(recipients, expectedRecipients) {
+				t.Errorf("Expected recipients %v, got %v", expectedRecipients, recipients)
+			}
+
+			if data != msg {
+				t.Errorf("Expected data %q, got %q", msg, data)
+			}
+
+			expectedAuth := ""
+			if c.Auth != AuthNone {
+				expectedAuth = strings.ToUpper(string(c.Auth))
+			}
+			if authed != expectedAuth {
+				t.Errorf("Expected auth '%s', got '%s'", expectedAuth, authed)
+			}
+
+			if isTLS != c.ExpectTLS {
+				t.Errorf("Expected TLS %t, got %t", c.ExpectTLS, isTLS)
+			}
+		})
+	}
+}
+
+func TestEmailChannelBccOnly(t *testing.T) {
+	server := NewMockSMTPServer(t, nil, false)
+
+	channel := EmailChannel{
+		Transport: &SMTPTransport{
+			Host:    "localhost",
+			Port:    server.Port(),
+			TLSMode: TLSModeNone,
+			Auth:    AuthNone,
+			From:    "notifier@example.com",
+			Bcc:     []string{"a@example.com", "b@example.com"},
+			Timeout: 5 * time.Second,
+		},
+		Templates: NewTemplates(""),
+	}
+
+	if err := channel.Transport.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := channel.Send(Notification{
+		ID:    "1",
+		Event: EventNewListing,
+		Item:  MarketItem{ID: 1, Name: "Artist - Title", LowestPrice: 20},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, recipients, data, _, _ := server.Received()
+
+	expectedRecipients := []string{"a@example.com", "b@example.com"}
+	if !cmp.Equal(recipients, expectedRecipients) {
+		t.Errorf("Expected recipients %v, got %v", expectedRecipients, recipients)
+	}
+
+	if !strings.Contains(data, "To: undisclosed-recipients:;\r\n") {
+		t.Errorf("Expected undisclosed recipients header, got %q", data)
+	}
+
+	if strings.Contains(data, "a@example.com") {
+		t.Errorf("Expected Bcc recipients to be left out of the headers, got %q", data)
+	}
+}
+
+func TestSMTPTransportStartTLSRequired(t *testing.T) {
+	server := NewMockSMTPServer(t, nil, false)
+
+	transport := &SMTPTransport{
+		Host:    "localhost",
+		Port:    server.Port(),
+		TLSMode: TLSModeStartTLSRequired,
+		Auth:    AuthNone,
+		From:    "notifier@example.com",
+		To:      []string{"a@example.com"},
+		Timeout: 5 * time.Second,
+	}
+
+	if err := transport.Send([]byte("Subject: test\r\n\r\nHello")); err == nil {
+		t.Error("Expected error when server doesn't support STARTTLS")
+	}
+}
+
+func TestSMTPTransportTimeout(t *testing.T) {
+	// Accept connections but never greet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	transport := &SMTPTransport{
+		Host:    "127.0.0.1",
+		Port:    l.Addr().(*net.TCPAddr).Port,
+		TLSMode: TLSModeNone,
+		Auth:    AuthNone,
+		From:    "notifier@example.com",
+		To:      []string{"a@example.com"},
+		Timeout: 100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	if err := transport.Send([]byte("Subject: test\r\n\r\nHello")); err == nil {
+		t.Error("Expected timeout error")
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected send to time out quickly, took %s", elapsed)
+	}
+}
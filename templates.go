@@ -0,0 +1,306 @@
+package notifier
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Default templates compiled into the binary
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Event is the kind of notification being sent, each event has its own
+// templates per channel
+type Event string
+
+const (
+	EventNewListing Event = "new_listing"
+	// EventAdminAlert is a problem with the notifier itself, its message
+	// is in TemplateData.Message
+	EventAdminAlert Event = "admin_alert"
+)
+
+// Template formats. Html templates are escaped, all others are plain text
+const (
+	FormatHTML     = "html"
+	FormatText     = "txt"
+	FormatSubject  = "subject.txt"
+	FormatMarkdown = "md"
+)
+
+// ListingData describes a single marketplace listing for templates
+type ListingData struct {
+	ID               string
+	URL              string
+	Price            float64
+	Currency         string
+	OriginalPrice    float64
+	OriginalCurrency string
+	Shipping         float64
+	// Landed is the estimated cost delivered, including ImportCharges
+	Landed          float64
+	ImportCharges   float64
+	MediaCondition  string
+	SleeveCondition string
+	Seller          string
+	SellerURL       string
+	ShipsFrom       string
+	Country         string
+	Comment         string
+	Format          string
+	Photos          int
+	AcceptsOffers   bool
+}
+
+// TemplateData is the data available to notification templates
+type TemplateData struct {
+	Event               Event
+	ReleaseID           int
+	Name                string
+	URL                 string
+	SellURL             string
+	NumForSale          int
+	LowestPrice         float64
+	PreviousLowestPrice float64
+	Currency            string
+	Threshold           float64
+	Listings            []ListingData
+	Message             string
+
+	// Release metadata, empty if the release couldn't be fetched
+	Title         string
+	Artists       string
+	Year          int
+	Label         string
+	CatalogNumber string
+	Formats       string
+	Country       string
+	Thumbnail     string
+}
+
+// ListingURL returns the marketplace page of a listing
+func ListingURL(listingID string) string {
+	return "https://www.discogs.com/sell/item/" + listingID
+}
+
+// SellerURL returns the profile page of a seller, empty if the seller
+// isn't known
+func SellerURL(sellerID string) string {
+	if sellerID == "" {
+		return ""
+	}
+
+	return "https://www.discogs.com/seller/" + sellerID + "/profile"
+}
+
+// SellURL returns the marketplace page listing all copies of a release
+func SellURL(releaseID int) string {
+	return fmt.Sprintf("https://www.discogs.com/sell/release/%d", releaseID)
+}
+
+// NewTemplateData creates the template data for a notification
+func NewTemplateData(n Notification) TemplateData {
+	item := n.Item
+
+	event := n.Event
+	if event == "" {
+		event = EventNewListing
+	}
+
+	listings := make([]ListingData, len(item.Listings))
+	for i, listing := range item.Listings {
+		listings[i] = ListingData{
+			ID:               listing.ID,
+			URL:              ListingURL(listing.ID),
+			Price:            float64(listing.Price) / 100,
+			Currency:         item.Currency,
+			OriginalPrice:    float64(listing.OriginalPrice) / 100,
+			OriginalCurrency: listing.OriginalCurrency,
+			Shipping:         float64(listing.Shipping) / 100,
+			Landed:           float64(listing.Landed.Total()) / 100,
+			ImportCharges:    float64(listing.Landed.Charges()) / 100,
+			MediaCondition:   listing.MediaCondition.String(),
+			SleeveCondition:  listing.SleeveCondition.String(),
+			Seller:           listing.Seller,
+			SellerURL:        SellerURL(listing.SellerID),
+			ShipsFrom:        strings.TrimSpace(listing.Location),
+			Country:          listing.Country,
+			Comment:          listing.Comment,
+			Format:           listing.Format,
+			Photos:           listing.Photos,
+			AcceptsOffers:    listing.AcceptsOffers,
+		}
+	}
+
+	data := TemplateData{
+		Event:               event,
+		ReleaseID:           item.ID,
+		Name:                item.Name,
+		URL:                 item.URL,
+		SellURL:             SellURL(item.ID),
+		NumForSale:          item.NumForSale,
+		LowestPrice:         item.LowestPrice,
+		PreviousLowestPrice: item.PreviousLowestPrice,
+		Currency:            item.Currency,
+		Threshold:           item.MinimumPrice,
+		Listings:            listings,
+		Message:             n.Message,
+	}
+
+	if release := item.Release; release != nil {
+		data.Title = release.Title
+		data.Artists = release.ArtistNames()
+		data.Year = release.Year
+		data.Label = release.LabelName()
+		data.CatalogNumber = release.CatalogNumber()
+		data.Formats = release.FormatDescription()
+		data.Country = release.Country
+		data.Thumbnail = release.Thumb
+	}
+
+	return data
+}
+
+// SampleNotification returns a notification with example data, used to
+// check a channel is configured correctly
+func SampleNotification(channel Channel) Notification {
+	return Notification{
+		ID:        "test",
+		Event:     EventNewListing,
+		Channel:   channel.Name(),
+		Recipient: channel.Recipient(),
+		CreatedAt: time.Now(),
+		Item: MarketItem{
+			ID:                  1,
+			Name:                "Test Notification - Discogs Notifier",
+			URL:                 "https://www.discogs.com/release/1",
+			NumForSale:          3,
+			LowestPrice:         25,
+			PreviousLowestPrice: 30,
+			MinimumPrice:        30,
+			Rules:               Rules{MaxPrice: 30},
+			Currency:            "USD",
+			Listings: []ListedItem{
+				ListedItem{
+					ID:                "1",
+					Seller:            "example-seller",
+					SellerID:          "example-seller",
+					Location:          "United States",
+					Country:           "US",
+					Price:             2500,
+					OriginalPrice:     1800,
+					OriginalCurrency:  "USD",
+					Shipping:          700,
+					ConvertedShipping: 700,
+					Landed:            LandedCost{Price: 2500, Shipping: 700, VAT: 320},
+					MediaCondition:    GradeNearMint,
+					SleeveCondition:   GradeVeryGoodPlus,
+					Comment:           "Example listing comment",
+					Format:            "LP, Album",
+					Photos:            2,
+					AcceptsOffers:     true,
+				},
+			},
+		},
+	}
+}
+
+// templateFuncs are the functions available to all templates
+var templateFuncs = map[string]interface{}{
+	"money": FormatMoney,
+}
+
+// executor is satisfied by both html and text templates
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// Templates renders notification templates. Templates are looked up as
+// '{channel}/{event}.{format}', first in the override directory and then
+// in the templates embedded in the binary. Parsed templates are cached
+type Templates struct {
+	dir   string
+	mu    sync.Mutex
+	cache map[string]executor
+}
+
+// NewTemplates creates Templates which prefer templates found in dir
+// over the embedded defaults. An empty dir uses only the defaults
+func NewTemplates(dir string) *Templates {
+	return &Templates{
+		dir:   dir,
+		cache: map[string]executor{},
+	}
+}
+
+// read returns the source of a template, preferring the override directory
+func (t *Templates) read(name string) ([]byte, error) {
+	if t.dir != "" {
+		src, err := ioutil.ReadFile(filepath.Join(t.dir, filepath.FromSlash(name)))
+		if err == nil {
+			return src, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return embeddedTemplates.ReadFile(path.Join("templates", name))
+}
+
+// lookup returns the parsed template for a channel, event and format
+func (t *Templates) lookup(channel string, event Event, format string) (executor, error) {
+	name := fmt.Sprintf("%s/%s.%s", channel, event, format)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tmpl, ok := t.cache[name]; ok {
+		return tmpl, nil
+	}
+
+	src, err := t.read(name)
+	if err != nil {
+		return nil, fmt.Errorf("template %s not found: %v", name, err)
+	}
+
+	var tmpl executor
+	if format == FormatHTML {
+		tmpl, err = htmltemplate.New(name).Funcs(templateFuncs).Parse(string(src))
+	} else {
+		tmpl, err = template.New(name).Funcs(templateFuncs).Parse(string(src))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.cache[name] = tmpl
+
+	return tmpl, nil
+}
+
+// Render executes the template for a channel, event and format with data
+func (t *Templates) Render(channel string, event Event, format string, data TemplateData) (string, error) {
+	tmpl, err := t.lookup(channel, event, format)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,161 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testNotification() Notification {
+	return Notification{
+		ID:      "abc123",
+		Event:   EventNewListing,
+		Channel: "email",
+		Item: MarketItem{
+			ID:                  42,
+			NumForSale:          3,
+			MinimumPrice:        30,
+			LowestPrice:         25.5,
+			PreviousLowestPrice: 28,
+			Name:                "Artist - <Title>",
+			URL:                 "https://www.discogs.com/release/42",
+			Currency:            "AUD",
+			Listings: []ListedItem{
+				ListedItem{
+					ID:               "1001",
+					Seller:           "record_shop",
+					SellerID:         "record_shop",
+					Location:         " Australia",
+					Price:            2550,
+					OriginalPrice:    2000,
+					OriginalCurrency: "AUD",
+					Shipping:         550,
+					MediaCondition:   GradeNearMint,
+					SleeveCondition:  GradeVeryGoodPlus,
+					Comment:          "Light <scuffs>",
+					Format:           "LP, Album",
+					Photos:           3,
+					AcceptsOffers:    true,
+				},
+			},
+		},
+	}
+}
+
+func TestTemplatesRender(t *testing.T) {
+	templates := NewTemplates("")
+	data := NewTemplateData(testNotification())
+
+	cases := []struct {
+		Channel  string
+		Format   string
+		Expected []string
+	}{
+		{"email", FormatSubject, []string{"New Artist - <Title> listed!"}},
+		{"email", FormatText, []string{"25.50 AUD", "previously 28.00 AUD", "threshold is 30.00 AUD", "Near Mint", "Very Good Plus", "record_shop", "ships from Australia", "https://www.discogs.com/sell/item/1001", "(20.00 AUD + 5.50 AUD shipping)", "accepts offers", "LP, Album, 3 photos", `"Light <scuffs>"`}},
+		{"email", FormatHTML, []string{"Artist - &lt;Title&gt;", "25.50 AUD", "Near Mint", `href="https://www.discogs.com/sell/item/1001"`, `href="https://www.discogs.com/seller/record_shop/profile"`, "Light &lt;scuffs&gt;"}},
+		{"chat", FormatMarkdown, []string{"[Artist - <Title>](https://www.discogs.com/sell/release/42)", "[25.50 AUD](https://www.discogs.com/sell/item/1001)", "accepts offers", "_Light <scuffs>_"}},
+	}
+
+	for _, c := range cases {
+		rendered, err := templates.Render(c.Channel, EventNewListing, c.Format, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, expected := range c.Expected {
+			if !strings.Contains(rendered, expected) {
+				t.Errorf("Expected %s/%s to contain '%s', got:\n%s", c.Channel, c.Format, expected, rendered)
+			}
+		}
+	}
+}
+
+func TestTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(dir, "email"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ioutil.WriteFile(filepath.Join(dir, "email", "new_listing.subject.txt"), []byte("Deal: {{.Name}}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templates := NewTemplates(dir)
+	data := NewTemplateData(testNotification())
+
+	subject, err := templates.Render("email", EventNewListing, FormatSubject, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Deal: Artist - <Title>" {
+		t.Errorf("Expected overridden subject, got '%s'", subject)
+	}
+
+	// Templates missing from the override directory fall back to the defaults
+	if _, err := templates.Render("email", EventNewListing, FormatText, data); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := templates.Render("email", Event("unknown"), FormatText, data); err == nil {
+		t.Error("Expected error for unknown event")
+	}
+}
+
+func TestChatChannel(t *testing.T) {
+	var received chatMessage
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	channel := NewChatChannel(ts.URL+"/hooks/secret", NewTemplates(""))
+
+	if recipient := channel.Recipient(); strings.Contains(recipient, "secret") {
+		t.Errorf("Expected recipient to hide the webhook path, got %s", recipient)
+	}
+
+	if err := channel.Send(testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(received.Text, "New listing for") {
+		t.Errorf("Expected chat message text, got '%s'", received.Text)
+	}
+}
+
+func TestSampleNotification(t *testing.T) {
+	var received chatMessage
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	channel := NewChatChannel(ts.URL, NewTemplates(""))
+
+	n := SampleNotification(channel)
+	if n.Channel != "chat" || n.Recipient != channel.Recipient() {
+		t.Errorf("Expected sample notification addressed to the channel, got %s %s", n.Channel, n.Recipient)
+	}
+
+	if err := channel.Send(n); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(received.Text, "Test Notification") {
+		t.Errorf("Expected sample chat message, got '%s'", received.Text)
+	}
+}